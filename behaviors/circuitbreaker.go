@@ -0,0 +1,257 @@
+// Tideland Go Cells - Behaviors - Circuit Breaker
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicCircuitState is used for the events signalling a
+	// change of the circuit state.
+	TopicCircuitState = "circuit:state"
+
+	// TopicCircuitRejected is used for the events received while
+	// the circuit is open and no fallback is defined.
+	TopicCircuitRejected = "circuit:rejected"
+
+	// TopicCircuitProbe is used internally to switch from open
+	// to half-open.
+	TopicCircuitProbe = "circuit:probe"
+)
+
+// CircuitState describes the state of a circuit breaker.
+type CircuitState int
+
+// States of the circuit breaker.
+const (
+	// CircuitClosed passes all events to the processor.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all events.
+	CircuitOpen
+
+	// CircuitHalfOpen passes the next event as trial to the processor.
+	CircuitHalfOpen
+)
+
+// String implements the fmt.Stringer interface.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+//--------------------
+// CIRCUIT BREAKER BEHAVIOR
+//--------------------
+
+// CircuitStateChange is emitted with the topic "circuit:state" when
+// the state of the circuit breaker changes.
+type CircuitStateChange struct {
+	From CircuitState
+	To   CircuitState
+}
+
+// CircuitRejection is emitted with the topic "circuit:rejected" for
+// each event received while the circuit is open.
+type CircuitRejection struct {
+	Topic string
+	Data  []byte
+}
+
+// Payload returns the payload of the rejected event.
+func (r CircuitRejection) Payload() (cells.Payload, error) {
+	return cells.NewPayload(r.Data)
+}
+
+// circuitBreakerBehavior protects a processor calling external systems.
+type circuitBreakerBehavior struct {
+	cell       cells.Cell
+	process    SimpleProcessor
+	fallback   SimpleProcessor
+	threshold  int
+	window     time.Duration
+	timeout    time.Duration
+	state      CircuitState
+	failures   []time.Time
+	generation int
+	timer      *time.Timer
+}
+
+// NewCircuitBreakerBehavior creates a behavior passing the received
+// events to the processor as long as the circuit is closed. Errors of
+// the processor and panics are logged and counted. If threshold errors
+// happen during the window the circuit opens. Now all events are passed
+// to the fallback or, if it is nil, emitted as CircuitRejection with
+// the topic "circuit:rejected". After the timeout the circuit is
+// half-open and the next event is passed as trial to the processor.
+// Success closes the circuit, an error opens it again. Each state
+// change is emitted as CircuitStateChange with the topic
+// "circuit:state". A "reset" closes the circuit. The threshold is
+// at least 1, a window not larger than 0 is set to one minute.
+func NewCircuitBreakerBehavior(processor, fallback SimpleProcessor, threshold int, window, timeout time.Duration) cells.Behavior {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &circuitBreakerBehavior{
+		process:   processor,
+		fallback:  fallback,
+		threshold: threshold,
+		window:    window,
+		timeout:   timeout,
+		state:     CircuitClosed,
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *circuitBreakerBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *circuitBreakerBehavior) Terminate() error {
+	b.stopTimer()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *circuitBreakerBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicCircuitProbe:
+		var generation int
+		if err := event.Payload().Unmarshal(&generation); err != nil {
+			return err
+		}
+		if b.state != CircuitOpen || generation != b.generation {
+			return nil
+		}
+		return b.changeState(CircuitHalfOpen)
+	case cells.TopicReset:
+		b.stopTimer()
+		b.failures = nil
+		if b.state != CircuitClosed {
+			return b.changeState(CircuitClosed)
+		}
+	default:
+		if b.state == CircuitOpen {
+			return b.reject(event)
+		}
+		if err := b.safeProcess(event); err != nil {
+			logger.Warningf("circuit breaker %q processor failed: %v", b.cell.ID(), err)
+			return b.fail()
+		}
+		if b.state == CircuitHalfOpen {
+			b.failures = nil
+			return b.changeState(CircuitClosed)
+		}
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *circuitBreakerBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// safeProcess calls the processor and returns panics as errors,
+// so that they don't count as recoverings of the cell.
+func (b *circuitBreakerBehavior) safeProcess(event cells.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("processor panicked: %v", r)
+		}
+	}()
+	return b.process(b.cell, event)
+}
+
+// fail counts a failure and opens the circuit if needed.
+func (b *circuitBreakerBehavior) fail() error {
+	now := time.Now()
+	if b.state == CircuitHalfOpen {
+		return b.open()
+	}
+	// Forget failures outside the window.
+	first := 0
+	for first < len(b.failures) && now.Sub(b.failures[first]) >= b.window {
+		first++
+	}
+	b.failures = append(b.failures[first:], now)
+	if len(b.failures) >= b.threshold {
+		return b.open()
+	}
+	return nil
+}
+
+// open opens the circuit and starts the timer for the probe.
+func (b *circuitBreakerBehavior) open() error {
+	b.stopTimer()
+	b.failures = nil
+	b.generation++
+	generation := b.generation
+	b.timer = time.AfterFunc(b.timeout, func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicCircuitProbe, generation); err != nil {
+			logger.Errorf("circuit breaker %q cannot be probed: %v", b.cell.ID(), err)
+		}
+	})
+	return b.changeState(CircuitOpen)
+}
+
+// reject passes the event to the fallback or emits it as rejected.
+func (b *circuitBreakerBehavior) reject(event cells.Event) error {
+	if b.fallback != nil {
+		return b.fallback(b.cell, event)
+	}
+	return b.cell.EmitNew(TopicCircuitRejected, CircuitRejection{
+		Topic: event.Topic(),
+		Data:  event.Payload().Bytes(),
+	})
+}
+
+// changeState sets the new state and emits the change.
+func (b *circuitBreakerBehavior) changeState(state CircuitState) error {
+	change := CircuitStateChange{
+		From: b.state,
+		To:   state,
+	}
+	b.state = state
+	return b.cell.EmitNew(TopicCircuitState, change)
+}
+
+// stopTimer stops a running probe timer.
+func (b *circuitBreakerBehavior) stopTimer() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
+
+// EOF
@@ -0,0 +1,241 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Circuit Breaker
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCircuitBreakerBehavior tests the circuit breaker behavior
+// moving through its states.
+func TestCircuitBreakerBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("circuit-breaker-behavior")
+	defer env.Stop()
+
+	processor := func(cell cells.Cell, event cells.Event) error {
+		if event.Payload().String() == "fail" {
+			return errors.New("failed")
+		}
+		return cell.EmitNew("processed", event.Payload().String())
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicCircuitState:
+			var change behaviors.CircuitStateChange
+			if err := event.Payload().Unmarshal(&change); err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("state %v > %v", change.From, change.To)
+		case behaviors.TopicCircuitRejected:
+			var rejection behaviors.CircuitRejection
+			if err := event.Payload().Unmarshal(&rejection); err != nil {
+				return err
+			}
+			payload, err := rejection.Payload()
+			if err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("rejected %s %s", rejection.Topic, payload)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	env.StartCell("breaker", behaviors.NewCircuitBreakerBehavior(processor, nil, 2, time.Second, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("breaker", "signaler")
+
+	env.EmitNew("breaker", "call", "ok")
+	env.EmitNew("breaker", "call", "fail")
+	env.EmitNew("breaker", "call", "fail")
+	env.EmitNew("breaker", "call", "ok")
+
+	assert.Wait(sigc, "processed ok", time.Second)
+	assert.Wait(sigc, "state closed > open", time.Second)
+	assert.Wait(sigc, "rejected call ok", time.Second)
+	assert.Wait(sigc, "state open > half-open", time.Second)
+
+	// Failing trial opens again.
+	env.EmitNew("breaker", "call", "fail")
+	assert.Wait(sigc, "state half-open > open", time.Second)
+	assert.Wait(sigc, "state open > half-open", time.Second)
+
+	// Successful trial closes.
+	env.EmitNew("breaker", "call", "ok")
+	assert.Wait(sigc, "processed ok", time.Second)
+	assert.Wait(sigc, "state half-open > closed", time.Second)
+}
+
+// TestCircuitBreakerBehaviorFallback tests the circuit breaker behavior
+// using a fallback while open.
+func TestCircuitBreakerBehaviorFallback(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("circuit-breaker-behavior-fallback")
+	defer env.Stop()
+
+	fallback := func(cell cells.Cell, event cells.Event) error {
+		return cell.EmitNew("fallback", event.Payload().String())
+	}
+	processor := func(cell cells.Cell, event cells.Event) error {
+		if event.Payload().String() == "fail" {
+			return errors.New("failed")
+		}
+		return cell.EmitNew("processed", event.Payload().String())
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicCircuitState:
+			var change behaviors.CircuitStateChange
+			if err := event.Payload().Unmarshal(&change); err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("state %v > %v", change.From, change.To)
+		case behaviors.TopicCircuitRejected:
+			var rejection behaviors.CircuitRejection
+			if err := event.Payload().Unmarshal(&rejection); err != nil {
+				return err
+			}
+			payload, err := rejection.Payload()
+			if err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("rejected %s %s", rejection.Topic, payload)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	env.StartCell("breaker", behaviors.NewCircuitBreakerBehavior(processor, fallback, 2, time.Second, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("breaker", "signaler")
+
+	env.EmitNew("breaker", "call", "fail")
+	env.EmitNew("breaker", "call", "fail")
+	env.EmitNew("breaker", "call", "ok")
+
+	assert.Wait(sigc, "state closed > open", time.Second)
+	assert.Wait(sigc, "fallback ok", time.Second)
+}
+
+// TestCircuitBreakerBehaviorPanic tests the circuit breaker behavior
+// counting panics of the processor as failures.
+func TestCircuitBreakerBehaviorPanic(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("circuit-breaker-behavior-panic")
+	defer env.Stop()
+
+	processor := func(cell cells.Cell, event cells.Event) error {
+		if event.Payload().String() == "panic" {
+			panic("failed")
+		}
+		return cell.EmitNew("processed", event.Payload().String())
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicCircuitState:
+			var change behaviors.CircuitStateChange
+			if err := event.Payload().Unmarshal(&change); err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("state %v > %v", change.From, change.To)
+		case behaviors.TopicCircuitRejected:
+			var rejection behaviors.CircuitRejection
+			if err := event.Payload().Unmarshal(&rejection); err != nil {
+				return err
+			}
+			payload, err := rejection.Payload()
+			if err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("rejected %s %s", rejection.Topic, payload)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	env.StartCell("breaker", behaviors.NewCircuitBreakerBehavior(processor, nil, 2, time.Second, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("breaker", "signaler")
+
+	env.EmitNew("breaker", "call", "panic")
+	env.EmitNew("breaker", "call", "panic")
+	env.EmitNew("breaker", "call", "ok")
+
+	assert.Wait(sigc, "state closed > open", time.Second)
+	assert.Wait(sigc, "rejected call ok", time.Second)
+}
+
+// TestCircuitBreakerBehaviorManyPanics tests the circuit breaker
+// behavior surviving more panics than the cell could recover.
+func TestCircuitBreakerBehaviorManyPanics(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("circuit-breaker-behavior-many-panics")
+	defer env.Stop()
+
+	processor := func(cell cells.Cell, event cells.Event) error {
+		if event.Payload().String() == "panic" {
+			panic("failed")
+		}
+		return cell.EmitNew("processed", event.Payload().String())
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicCircuitState:
+			var change behaviors.CircuitStateChange
+			if err := event.Payload().Unmarshal(&change); err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("state %v > %v", change.From, change.To)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	// Without a window the failures are counted during a minute.
+	env.StartCell("breaker", behaviors.NewCircuitBreakerBehavior(processor, nil, 20, 0, time.Minute))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("breaker", "signaler")
+
+	for i := 0; i < 15; i++ {
+		env.EmitNew("breaker", "call", "panic")
+	}
+	env.EmitNew("breaker", "call", "ok")
+	assert.Wait(sigc, "processed ok", time.Second)
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("breaker", "call", "panic")
+	}
+	assert.Wait(sigc, "state closed > open", time.Second)
+}
+
+// EOF
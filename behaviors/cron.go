@@ -0,0 +1,203 @@
+// Tideland Go Cells - Behaviors - Cron
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CRON SPEC
+//--------------------
+
+// cronField describes the allowed values of one field.
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+// cronFields are the five fields of a cron spec.
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronSearchYears limits the search for the next time.
+const cronSearchYears = 5
+
+// CronSpec is a parsed cron expression with the fields minute,
+// hour, day of month, month, and day of week.
+type CronSpec struct {
+	spec    string
+	minutes uint64
+	hours   uint64
+	doms    uint64
+	months  uint64
+	dows    uint64
+	domStar bool
+	dowStar bool
+}
+
+// ParseCronSpec parses a cron expression. Each of the five fields
+// may be a "*", a value, a range like "1-5", or a list of them like
+// "1,3,10-12". Asterisks and ranges can be followed by a step like
+// "*/15" or "8-18/2". The days of week are 0 to 7 with 0 and 7 for
+// Sunday. If both, day of month and day of week, are restricted a
+// day matching one of them is sufficient.
+func ParseCronSpec(spec string) (*CronSpec, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, errors.New(ErrInvalidCronSpec, errorMessages, spec, "need five fields")
+	}
+	cs := &CronSpec{
+		spec: spec,
+	}
+	values := make([]uint64, len(cronFields))
+	for i, part := range parts {
+		bits, err := parseCronField(spec, part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = bits
+	}
+	cs.minutes = values[0]
+	cs.hours = values[1]
+	cs.doms = values[2]
+	cs.months = values[3]
+	// Sunday is 0 and 7.
+	cs.dows = values[4]
+	if cs.dows&(1<<7) != 0 {
+		cs.dows |= 1
+	}
+	cs.domStar = strings.HasPrefix(parts[2], "*")
+	cs.dowStar = strings.HasPrefix(parts[4], "*")
+	return cs, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (cs *CronSpec) String() string {
+	return cs.spec
+}
+
+// Next returns the first time after the passed one matching the
+// spec in the location of the passed time. Times skipped by a
+// daylight saving time change are skipped by the spec too, times
+// repeated by it only match once. If no time matches within the
+// next years the zero time is returned.
+func (cs *CronSpec) Next(after time.Time) time.Time {
+	loc := after.Location()
+	year, month, day := after.Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	end := date.AddDate(cronSearchYears, 0, 0)
+	for ; date.Before(end); date = date.AddDate(0, 0, 1) {
+		if !cs.matchesDay(date) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if cs.hours&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if cs.minutes&(1<<uint(minute)) == 0 {
+					continue
+				}
+				t := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+				if t.Hour() != hour || t.Minute() != minute {
+					// Skipped by daylight saving time.
+					continue
+				}
+				if t.After(after) {
+					return t
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay checks if the spec matches the day of the date.
+func (cs *CronSpec) matchesDay(date time.Time) bool {
+	if cs.months&(1<<uint(date.Month())) == 0 {
+		return false
+	}
+	dom := cs.doms&(1<<uint(date.Day())) != 0
+	dow := cs.dows&(1<<uint(date.Weekday())) != 0
+	if cs.domStar || cs.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parseCronField parses one field into a bit set.
+func parseCronField(spec, field string, cf cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, errors.New(ErrInvalidCronSpec, errorMessages, spec, "invalid step of "+cf.name)
+			}
+			rangePart, step = part[:i], s
+		}
+		first, last := cf.min, cf.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			f, err := parseCronValue(spec, bounds[0], cf)
+			if err != nil {
+				return 0, err
+			}
+			l, err := parseCronValue(spec, bounds[1], cf)
+			if err != nil {
+				return 0, err
+			}
+			if f > l {
+				return 0, errors.New(ErrInvalidCronSpec, errorMessages, spec, "invalid range of "+cf.name)
+			}
+			first, last = f, l
+		default:
+			v, err := parseCronValue(spec, rangePart, cf)
+			if err != nil {
+				return 0, err
+			}
+			first = v
+			if step == 1 {
+				last = v
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses one value of a field.
+func parseCronValue(spec, value string, cf cronField) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil || v < cf.min || v > cf.max {
+		return 0, errors.New(ErrInvalidCronSpec, errorMessages, spec, "invalid value "+value+" of "+cf.name)
+	}
+	return v, nil
+}
+
+// EOF
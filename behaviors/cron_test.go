@@ -0,0 +1,122 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Cron
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/errors"
+
+	"github.com/tideland/gocells/behaviors"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestParseCronSpec tests the parsing of valid and invalid cron specs.
+func TestParseCronSpec(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	for _, spec := range []string{
+		"* * * * *",
+		"*/15 8-18/2 1,15 * 1-5",
+		"0 0 * 1-3,10 7",
+		"5/10 * * * *",
+	} {
+		_, err := behaviors.ParseCronSpec(spec)
+		assert.Nil(err, spec)
+	}
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		_, err := behaviors.ParseCronSpec(spec)
+		assert.True(errors.IsError(err, behaviors.ErrInvalidCronSpec), spec)
+	}
+}
+
+// TestCronSpecNext tests the calculation of the next times.
+func TestCronSpecNext(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	utc := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		assert.Nil(err)
+		return t
+	}
+	tests := []struct {
+		spec  string
+		after string
+		next  string
+	}{
+		{"* * * * *", "2017-05-10 12:30", "2017-05-10 12:31"},
+		{"*/15 * * * *", "2017-05-10 12:30", "2017-05-10 12:45"},
+		{"0 9 * * *", "2017-05-10 12:30", "2017-05-11 09:00"},
+		{"0 0 1 * *", "2017-12-10 12:30", "2018-01-01 00:00"},
+		// 2017-05-13 is a Saturday.
+		{"30 8 * * 1-5", "2017-05-12 12:30", "2017-05-15 08:30"},
+		{"0 0 * * 7", "2017-05-12 12:30", "2017-05-14 00:00"},
+		// Day of month or day of week.
+		{"0 0 20 * 0", "2017-05-12 12:30", "2017-05-14 00:00"},
+		{"0 0 20 * 0", "2017-05-14 12:30", "2017-05-20 00:00"},
+		{"0 0 29 2 *", "2017-03-01 00:00", "2020-02-29 00:00"},
+	}
+	for _, test := range tests {
+		spec, err := behaviors.ParseCronSpec(test.spec)
+		assert.Nil(err)
+		assert.Equal(spec.Next(utc(test.after)), utc(test.next), test.spec)
+	}
+	// Never matching.
+	spec, err := behaviors.ParseCronSpec("0 0 31 2 *")
+	assert.Nil(err)
+	assert.True(spec.Next(utc("2017-01-01 00:00")).IsZero())
+}
+
+// TestCronSpecNextDST tests the calculation of the next times
+// during daylight saving time changes.
+func TestCronSpecNextDST(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(err)
+
+	// Skipped hour at 2017-03-26 02:00.
+	spec, err := behaviors.ParseCronSpec("30 2 * * *")
+	assert.Nil(err)
+	next := spec.Next(time.Date(2017, 3, 26, 0, 0, 0, 0, berlin))
+	assert.Equal(next, time.Date(2017, 3, 27, 2, 30, 0, 0, berlin))
+
+	spec, err = behaviors.ParseCronSpec("0 * * * *")
+	assert.Nil(err)
+	next = spec.Next(time.Date(2017, 3, 26, 1, 30, 0, 0, berlin))
+	assert.Equal(next.Hour(), 3)
+	assert.Equal(next.Sub(time.Date(2017, 3, 26, 1, 30, 0, 0, berlin)), 30*time.Minute)
+
+	// Repeated hour at 2017-10-29 02:00 only matches once.
+	spec, err = behaviors.ParseCronSpec("30 2 * * *")
+	assert.Nil(err)
+	next = spec.Next(time.Date(2017, 10, 29, 0, 0, 0, 0, berlin))
+	assert.Equal(next.Day(), 29)
+	next = spec.Next(next)
+	assert.Equal(next.Day(), 30)
+}
+
+// EOF
@@ -0,0 +1,132 @@
+// Tideland Go Cells - Behaviors - Debounce
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicDebounceQuiet is used internally to signal the end
+	// of a quiet period.
+	TopicDebounceQuiet = "debounce:quiet"
+)
+
+// DebounceMode defines which event of a burst is emitted.
+type DebounceMode int
+
+// Modes of the debounce behavior.
+const (
+	// DebounceTrailing emits the last event of a burst after
+	// the quiet period.
+	DebounceTrailing DebounceMode = iota + 1
+
+	// DebounceLeading emits the first event of a burst immediately
+	// and locks out the following ones until the quiet period.
+	DebounceLeading
+)
+
+//--------------------
+// DEBOUNCE BEHAVIOR
+//--------------------
+
+// debounceBehavior emits only one event of a burst.
+type debounceBehavior struct {
+	cell     cells.Cell
+	quiet    time.Duration
+	mode     DebounceMode
+	last     cells.Event
+	sequence int
+	timer    *time.Timer
+	lockout  time.Time
+}
+
+// NewDebounceBehavior creates a behavior emitting only one of the events
+// received in a burst. A burst ends when no event has been received for
+// the quiet duration. The mode defines if the first event of the burst
+// is emitted immediately or the last one after the quiet duration.
+func NewDebounceBehavior(quiet time.Duration, mode DebounceMode) cells.Behavior {
+	return &debounceBehavior{
+		quiet: quiet,
+		mode:  mode,
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *debounceBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *debounceBehavior) Terminate() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *debounceBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicDebounceQuiet:
+		var sequence int
+		if err := event.Payload().Unmarshal(&sequence); err != nil {
+			return err
+		}
+		if sequence != b.sequence || b.last == nil {
+			// Burst continued.
+			return nil
+		}
+		last := b.last
+		b.last = nil
+		return b.cell.Emit(last)
+	default:
+		if b.mode == DebounceLeading {
+			now := time.Now()
+			locked := now.Before(b.lockout)
+			b.lockout = now.Add(b.quiet)
+			if locked {
+				return nil
+			}
+			return b.cell.Emit(event)
+		}
+		b.last = event
+		b.sequence++
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		sequence := b.sequence
+		b.timer = time.AfterFunc(b.quiet, func() {
+			if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicDebounceQuiet, sequence); err != nil {
+				logger.Errorf("debounce of cell %q cannot end the quiet period: %v", b.cell.ID(), err)
+			}
+		})
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *debounceBehavior) Recover(err interface{}) error {
+	b.last = nil
+	return nil
+}
+
+// EOF
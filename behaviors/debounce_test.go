@@ -0,0 +1,81 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Debounce
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestDebounceBehaviorTrailing tests the debounce behavior emitting
+// the last event of a burst.
+func TestDebounceBehaviorTrailing(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("debounce-behavior-trailing")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("debounce", behaviors.NewDebounceBehavior(50*time.Millisecond, behaviors.DebounceTrailing))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("debounce", "signaler")
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("debounce", "sensor", i)
+	}
+	assert.Wait(sigc, "4", time.Second)
+
+	env.EmitNew("debounce", "sensor", 5)
+	assert.Wait(sigc, "5", time.Second)
+}
+
+// TestDebounceBehaviorLeading tests the debounce behavior emitting
+// the first event of a burst.
+func TestDebounceBehaviorLeading(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("debounce-behavior-leading")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("debounce", behaviors.NewDebounceBehavior(50*time.Millisecond, behaviors.DebounceLeading))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("debounce", "signaler")
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("debounce", "sensor", i)
+	}
+	assert.Wait(sigc, "0", time.Second)
+
+	time.Sleep(100 * time.Millisecond)
+	env.EmitNew("debounce", "sensor", 5)
+	assert.Wait(sigc, "5", time.Second)
+}
+
+// EOF
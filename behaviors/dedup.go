@@ -0,0 +1,326 @@
+// Tideland Go Cells - Behaviors - Deduplication
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// DEDUPLICATION BEHAVIOR
+//--------------------
+
+// defaultDedupRemembered is the default maximum number
+// of remembered identities.
+const defaultDedupRemembered = 10000
+
+// DedupIdentifier returns the identity of an event. Events with the
+// same identity are treated as duplicates.
+type DedupIdentifier func(event cells.Event) (string, error)
+
+// DedupStatus contains the number of forwarded and dropped events
+// as well as the number of currently remembered identities.
+type DedupStatus struct {
+	Forwarded  int
+	Dropped    int
+	Remembered int
+}
+
+// PayloadIdentity is a DedupIdentifier returning a hash of the topic
+// and the payload of the event.
+func PayloadIdentity(event cells.Event) (string, error) {
+	h := fnv.New128a()
+	h.Write([]byte(event.Topic()))
+	h.Write([]byte{0})
+	h.Write(event.Payload().Bytes())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dedupMemory remembers seen identities.
+type dedupMemory interface {
+	// seen returns true if the identity has been seen before,
+	// otherwise it remembers it.
+	seen(identity string, now time.Time) bool
+
+	// len returns the number of remembered identities.
+	len() int
+
+	// clear forgets all identities.
+	clear()
+}
+
+// dedupBehavior only emits the first occurrence of events.
+type dedupBehavior struct {
+	cell     cells.Cell
+	identify DedupIdentifier
+	memory   dedupMemory
+	status   DedupStatus
+}
+
+// NewDedupBehavior creates a behavior emitting only the first occurrence of
+// events. The identifier returns the identity of the events, if it is nil
+// the PayloadIdentity is used. The identities are remembered for the ttl
+// after their last occurrence and at maximum the max last ones. A ttl of
+// 0 means no time limit, a max below 1 means the default of 10000. The topic "status" with a cell ID as payload emits the
+// DedupStatus to this cell, a "reset" forgets all identities.
+func NewDedupBehavior(identifier DedupIdentifier, ttl time.Duration, max int) cells.Behavior {
+	if max < 1 {
+		max = defaultDedupRemembered
+	}
+	return newDedupBehavior(identifier, newLRUMemory(ttl, max))
+}
+
+// NewProbabilisticDedupBehavior creates a deduplication behavior like
+// NewDedupBehavior but remembers the identities in bloom filters. So the
+// memory usage is fixed while at least the capacity last identities are
+// remembered. In return some events may be dropped with the given false
+// positive rate.
+func NewProbabilisticDedupBehavior(identifier DedupIdentifier, capacity int, falsePositiveRate float64) cells.Behavior {
+	return newDedupBehavior(identifier, newBloomMemory(capacity, falsePositiveRate))
+}
+
+// newDedupBehavior creates the deduplication behavior with
+// the passed memory.
+func newDedupBehavior(identifier DedupIdentifier, memory dedupMemory) cells.Behavior {
+	if identifier == nil {
+		identifier = PayloadIdentity
+	}
+	return &dedupBehavior{
+		identify: identifier,
+		memory:   memory,
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *dedupBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *dedupBehavior) Terminate() error {
+	b.memory.clear()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *dedupBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case cells.TopicStatus:
+		statusCell := event.Payload().String()
+		status := b.status
+		status.Remembered = b.memory.len()
+		b.cell.Environment().EmitNew(statusCell, b.cell.ID(), status)
+	case cells.TopicReset:
+		b.memory.clear()
+		b.status = DedupStatus{}
+	default:
+		identity, err := b.identify(event)
+		if err != nil {
+			return err
+		}
+		if b.memory.seen(identity, time.Now()) {
+			b.status.Dropped++
+			return nil
+		}
+		b.status.Forwarded++
+		return b.cell.Emit(event)
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *dedupBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+//--------------------
+// LRU MEMORY
+//--------------------
+
+// lruEntry is one remembered identity.
+type lruEntry struct {
+	identity string
+	last     time.Time
+}
+
+// lruMemory remembers identities for a duration and
+// a maximum number.
+type lruMemory struct {
+	ttl      time.Duration
+	max      int
+	entries  *list.List
+	elements map[string]*list.Element
+}
+
+// newLRUMemory creates the memory.
+func newLRUMemory(ttl time.Duration, max int) *lruMemory {
+	m := &lruMemory{
+		ttl: ttl,
+		max: max,
+	}
+	m.clear()
+	return m
+}
+
+// seen implements dedupMemory.
+func (m *lruMemory) seen(identity string, now time.Time) bool {
+	// Forget expired identities, the oldest are at the back.
+	if m.ttl > 0 {
+		for back := m.entries.Back(); back != nil; back = m.entries.Back() {
+			entry := back.Value.(*lruEntry)
+			if now.Sub(entry.last) < m.ttl {
+				break
+			}
+			m.remove(back)
+		}
+	}
+	if element, ok := m.elements[identity]; ok {
+		element.Value.(*lruEntry).last = now
+		m.entries.MoveToFront(element)
+		return true
+	}
+	m.elements[identity] = m.entries.PushFront(&lruEntry{identity, now})
+	if m.max > 0 && m.entries.Len() > m.max {
+		m.remove(m.entries.Back())
+	}
+	return false
+}
+
+// len implements dedupMemory.
+func (m *lruMemory) len() int {
+	return m.entries.Len()
+}
+
+// clear implements dedupMemory.
+func (m *lruMemory) clear() {
+	m.entries = list.New()
+	m.elements = make(map[string]*list.Element)
+}
+
+// remove removes one element.
+func (m *lruMemory) remove(element *list.Element) {
+	m.entries.Remove(element)
+	delete(m.elements, element.Value.(*lruEntry).identity)
+}
+
+//--------------------
+// BLOOM MEMORY
+//--------------------
+
+// bloomFilter is a simple bloom filter.
+type bloomFilter struct {
+	bits  []uint64
+	count int
+}
+
+// bloomMemory remembers identities in two rotating bloom filters.
+type bloomMemory struct {
+	capacity int
+	size     uint64
+	hashes   int
+	current  *bloomFilter
+	previous *bloomFilter
+}
+
+// newBloomMemory creates the memory.
+func newBloomMemory(capacity int, falsePositiveRate float64) *bloomMemory {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	size := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Ceil(size / float64(capacity) * math.Ln2))
+	m := &bloomMemory{
+		capacity: capacity,
+		size:     uint64(size),
+		hashes:   hashes,
+	}
+	m.clear()
+	return m
+}
+
+// seen implements dedupMemory.
+func (m *bloomMemory) seen(identity string, now time.Time) bool {
+	positions := m.positions(identity)
+	if m.current.contains(positions) || m.previous.contains(positions) {
+		return true
+	}
+	if m.current.count >= m.capacity {
+		m.previous = m.current
+		m.current = m.newFilter()
+	}
+	m.current.add(positions)
+	return false
+}
+
+// len implements dedupMemory.
+func (m *bloomMemory) len() int {
+	return m.current.count + m.previous.count
+}
+
+// clear implements dedupMemory.
+func (m *bloomMemory) clear() {
+	m.current = m.newFilter()
+	m.previous = m.newFilter()
+}
+
+// newFilter creates an empty filter.
+func (m *bloomMemory) newFilter() *bloomFilter {
+	return &bloomFilter{
+		bits: make([]uint64, (m.size+63)/64),
+	}
+}
+
+// positions returns the bit positions of an identity
+// using double hashing.
+func (m *bloomMemory) positions(identity string) []uint64 {
+	h := fnv.New128a()
+	h.Write([]byte(identity))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:])
+	positions := make([]uint64, m.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m.size
+	}
+	return positions
+}
+
+// contains checks if all positions are set.
+func (f *bloomFilter) contains(positions []uint64) bool {
+	for _, p := range positions {
+		if f.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets all positions.
+func (f *bloomFilter) add(positions []uint64) {
+	for _, p := range positions {
+		f.bits[p/64] |= 1 << (p % 64)
+	}
+	f.count++
+}
+
+// EOF
@@ -0,0 +1,188 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Deduplication
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestDedupBehavior tests the deduplication behavior.
+func TestDedupBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	statusc := audit.MakeSigChan()
+	env := cells.NewEnvironment("dedup-behavior")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.DedupStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status
+		return nil
+	}
+
+	env.StartCell("dedup", behaviors.NewDedupBehavior(nil, time.Minute, 0))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+	env.Subscribe("dedup", "signaler")
+
+	for _, word := range []string{"a", "b", "a", "c", "b"} {
+		env.EmitNew("dedup", "word", word)
+	}
+	env.EmitNew("dedup", cells.TopicStatus, "status")
+
+	assert.Wait(sigc, "a", time.Second)
+	assert.Wait(sigc, "b", time.Second)
+	assert.Wait(sigc, "c", time.Second)
+	assert.Wait(statusc, behaviors.DedupStatus{3, 2, 3}, time.Second)
+}
+
+// TestDedupBehaviorLimits tests the deduplication behavior with
+// ttl and maximum number of identities.
+func TestDedupBehaviorLimits(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	statusc := audit.MakeSigChan()
+	env := cells.NewEnvironment("dedup-behavior-limits")
+	defer env.Stop()
+
+	identifier := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.DedupStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status
+		return nil
+	}
+
+	env.StartCell("dedup", behaviors.NewDedupBehavior(identifier, 50*time.Millisecond, 2))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+	env.Subscribe("dedup", "signaler")
+
+	for _, word := range []string{"a", "b", "b", "c", "a"} {
+		env.EmitNew("dedup", "word", word)
+	}
+	time.Sleep(100 * time.Millisecond)
+	env.EmitNew("dedup", "word", "c")
+	env.EmitNew("dedup", cells.TopicStatus, "status")
+
+	// First a is forgotten after c due to the max.
+	for _, word := range []string{"a", "b", "c", "a", "c"} {
+		assert.Wait(sigc, word, time.Second)
+	}
+	// Only last c is remembered due to the ttl.
+	assert.Wait(statusc, behaviors.DedupStatus{5, 1, 1}, time.Second)
+}
+
+// TestDedupBehaviorDefaultMax tests the default maximum number
+// of identities without ttl.
+func TestDedupBehaviorDefaultMax(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	statusc := audit.MakeSigChan()
+	env := cells.NewEnvironment("dedup-behavior-default-max")
+	defer env.Stop()
+
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.DedupStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status
+		return nil
+	}
+
+	env.StartCell("dedup", behaviors.NewDedupBehavior(nil, 0, 0))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+
+	max := behaviors.DefaultDedupRemembered
+	for i := 0; i < max+10; i++ {
+		env.EmitNew("dedup", "number", i)
+	}
+	env.EmitNew("dedup", cells.TopicStatus, "status")
+
+	assert.Wait(statusc, behaviors.DedupStatus{max + 10, 0, max}, 5*time.Second)
+}
+
+// TestProbabilisticDedupBehavior tests the deduplication behavior
+// using bloom filters.
+func TestProbabilisticDedupBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	generator := audit.NewGenerator(audit.FixedRand())
+	sigc := make(chan interface{}, 100)
+	statusc := audit.MakeSigChan()
+	env := cells.NewEnvironment("probabilistic-dedup-behavior")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.DedupStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status
+		return nil
+	}
+
+	env.StartCell("dedup", behaviors.NewProbabilisticDedupBehavior(nil, 100, 0.001))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+	env.Subscribe("dedup", "signaler")
+
+	words := generator.Words(50)
+	unique := map[string]bool{}
+	for _, word := range words {
+		unique[word] = true
+	}
+	for i := 0; i < 3; i++ {
+		for _, word := range words {
+			env.EmitNew("dedup", "word", word)
+		}
+	}
+	env.EmitNew("dedup", cells.TopicStatus, "status")
+
+	for i := 0; i < len(unique); i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			assert.True(unique[v.(string)])
+			return nil
+		}, time.Second)
+	}
+	assert.Wait(statusc, behaviors.DedupStatus{len(unique), 150 - len(unique), len(unique)}, time.Second)
+}
+
+// EOF
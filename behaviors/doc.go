@@ -18,6 +18,9 @@
 //
 // Callback calls a number of passed functions for each received event.
 //
+// Circuit Breaker wraps a processor calling external systems and stops
+// calling it after too many errors for a while.
+//
 // Collector collects events which can be processed on demand.
 //
 // Combo waits for a user-defined combination of events.
@@ -27,6 +30,11 @@
 //
 // Counter counts events, the counters can be retrieved.
 //
+// Debounce emits only the first or the last event of a burst of events.
+//
+// Deduplication emits only the first occurrence of events and remembers
+// their identities with bounded memory.
+//
 // Evaluator evaluates events based on a user-defined function which
 // returns a rating.
 //
@@ -34,6 +42,9 @@
 //
 // Finite State Machine allows to build finite state machines for events.
 //
+// Join correlates events of multiple topics by a key within a time
+// window and emits the joined payloads.
+//
 // Key/Value collects and emits payloads grouped by topics.
 //
 // Logger logs received events with level INFO.
@@ -46,17 +57,31 @@
 // Pair checks if the event stream contains two matching ones based on a
 // user-based criterion in a given timespan.
 //
+// Partition routes events by a key to one behavior instance per key
+// and evicts idle partitions.
+//
+// Pool processes events concurrently in a number of workers and emits
+// the results in completion or input order.
+//
 // Rate measures times between a number of criterion fitting events and
 // emits the result.
 //
 // Rate Window checks if a number of events in a given timespan matches
 // a given criterion.
 //
+// Retry retries events failed by a processor with exponential backoff
+// and jitter.
+//
 // Round Robin distributes events round robin to its subscribers.
 //
+// Scheduler emits configured events at times defined by cron expressions.
+//
 // Sequence checks the event stream for a defined sequence of events
 // discovered by a user-defined criterion.
 //
+// Session groups events per key into sessions separated by inactivity
+// gaps and emits the processed content of each closed session.
+//
 // Simple Processor allows to not implement a behavior but only use
 // one function for event processing.
 //
@@ -64,7 +89,16 @@
 // Those have to emit it when receiving the topic "status" with a status
 // cell ID as payload.
 //
+// Throttle limits the number of emitted events per interval, exceeding
+// ones are dropped or delayed.
+//
 // Ticker emits tick events in a defined interval.
+//
+// Watchdog emits alarms when matching events stop arriving for a
+// duration, optionally per key, and recoveries when they arrive again.
+//
+// Window collects events in tumbling, hopping, or sliding time windows
+// and emits the processed content of each closed window.
 package behaviors
 
 // EOF
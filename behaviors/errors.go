@@ -24,12 +24,16 @@ const (
 	ErrCannotReadConfiguration = iota + 1
 	ErrCannotValidateConfiguration
 	ErrInvalidPayload
+	ErrInvalidCronSpec
+	ErrInvalidSchedule
 )
 
 var errorMessages = errors.Messages{
 	ErrCannotReadConfiguration:     "cannot read configuration",
 	ErrCannotValidateConfiguration: "configuration validation failed",
 	ErrInvalidPayload:              "payload '%v' does not exist or has wrong type",
+	ErrInvalidCronSpec:             "invalid cron spec %q: %s",
+	ErrInvalidSchedule:             "schedule %q is invalid",
 }
 
 // EOF
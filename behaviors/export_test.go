@@ -0,0 +1,41 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Export
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// DEDUPLICATION
+//--------------------
+
+// DefaultDedupRemembered is the default maximum number
+// of remembered identities.
+const DefaultDedupRemembered = defaultDedupRemembered
+
+//--------------------
+// SCHEDULER
+//--------------------
+
+// SchedulerFire is the payload to fire a schedule.
+type SchedulerFire = schedulerFire
+
+// SetSchedulerClock sets the function returning the current
+// time of a scheduler behavior before it is started.
+func SetSchedulerClock(behavior cells.Behavior, now func() time.Time) {
+	behavior.(*schedulerBehavior).now = now
+}
+
+// EOF
@@ -0,0 +1,257 @@
+// Tideland Go Cells - Behaviors - Join
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicJoin signals joined events.
+	TopicJoin = "join"
+
+	// TopicJoinTimeout signals an incomplete join after the
+	// window has been elapsed.
+	TopicJoinTimeout = "join:timeout"
+
+	// TopicJoinWindow is used internally to check the end of
+	// a join window.
+	TopicJoinWindow = "join:window"
+)
+
+// JoinMode defines how incomplete joins are handled.
+type JoinMode int
+
+// Modes of the join behavior.
+const (
+	// JoinInner only emits complete joins.
+	JoinInner JoinMode = iota + 1
+
+	// JoinLeftOuter additionally emits incomplete joins with the
+	// topic "join" if they contain the first topic.
+	JoinLeftOuter
+
+	// JoinTimeoutEmitting additionally emits incomplete joins with
+	// the topic "join:timeout".
+	JoinTimeoutEmitting
+)
+
+//--------------------
+// JOIN BEHAVIOR
+//--------------------
+
+// JoinKey returns the key used to correlate events of different topics.
+type JoinKey func(event cells.Event) (string, error)
+
+// Join contains the raw payloads of the joined events per topic.
+type Join struct {
+	Key      string
+	Payloads map[string][]byte
+	Complete bool
+}
+
+// Payload returns the payload of the joined event with the given topic.
+func (j Join) Payload(topic string) (cells.Payload, bool) {
+	data, ok := j.Payloads[topic]
+	if !ok {
+		return nil, false
+	}
+	payload, err := cells.NewPayload(data)
+	if err != nil {
+		return nil, false
+	}
+	return payload, true
+}
+
+// joinWindow identifies a join to check.
+type joinWindow struct {
+	Key string
+	ID  int
+}
+
+// pendingJoin contains the events of one key received so far.
+type pendingJoin struct {
+	id     int
+	events map[string]cells.Event
+	timer  *time.Timer
+}
+
+// joinBehavior correlates events of different topics by a key.
+type joinBehavior struct {
+	cell    cells.Cell
+	mode    JoinMode
+	key     JoinKey
+	window  time.Duration
+	topics  []string
+	pending map[string]*pendingJoin
+	counter int
+}
+
+// NewJoinBehavior creates a behavior correlating events with the passed
+// topics. The key function returns the key of each of those events. When
+// events of all topics with the same key have been received during the
+// window starting with the first one a Join is emitted with the topic
+// "join". Only the first event per topic and key is used. The mode defines
+// what happens when the window elapses without a complete join. Events
+// with other topics are ignored, a "reset" drops all pending joins.
+// Duplicate topics are only joined once.
+func NewJoinBehavior(mode JoinMode, key JoinKey, window time.Duration, topics ...string) cells.Behavior {
+	unique := []string{}
+	seen := make(map[string]bool)
+	for _, topic := range topics {
+		if !seen[topic] {
+			seen[topic] = true
+			unique = append(unique, topic)
+		}
+	}
+	return &joinBehavior{
+		mode:    mode,
+		key:     key,
+		window:  window,
+		topics:  unique,
+		pending: make(map[string]*pendingJoin),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *joinBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *joinBehavior) Terminate() error {
+	b.reset()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *joinBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicJoinWindow:
+		var jw joinWindow
+		if err := event.Payload().Unmarshal(&jw); err != nil {
+			return err
+		}
+		pj, ok := b.pending[jw.Key]
+		if !ok || pj.id != jw.ID {
+			// Already joined.
+			return nil
+		}
+		delete(b.pending, jw.Key)
+		return b.emitIncomplete(jw.Key, pj)
+	case cells.TopicReset:
+		b.reset()
+	default:
+		if !b.joins(event.Topic()) {
+			return nil
+		}
+		key, err := b.key(event)
+		if err != nil {
+			return err
+		}
+		pj, ok := b.pending[key]
+		if !ok {
+			pj = b.startJoin(key)
+		}
+		if _, ok := pj.events[event.Topic()]; !ok {
+			pj.events[event.Topic()] = event
+		}
+		if len(pj.events) == len(b.topics) {
+			pj.timer.Stop()
+			delete(b.pending, key)
+			return b.cell.EmitNew(TopicJoin, b.join(key, pj, true))
+		}
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *joinBehavior) Recover(err interface{}) error {
+	b.reset()
+	return nil
+}
+
+// joins checks if the topic is one of the joined ones.
+func (b *joinBehavior) joins(topic string) bool {
+	for _, t := range b.topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// startJoin creates a pending join and starts the timer
+// for its window.
+func (b *joinBehavior) startJoin(key string) *pendingJoin {
+	b.counter++
+	pj := &pendingJoin{
+		id:     b.counter,
+		events: make(map[string]cells.Event),
+	}
+	jw := joinWindow{key, pj.id}
+	pj.timer = time.AfterFunc(b.window, func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicJoinWindow, jw); err != nil {
+			logger.Errorf("join window of cell %q cannot be checked: %v", b.cell.ID(), err)
+		}
+	})
+	b.pending[key] = pj
+	return pj
+}
+
+// emitIncomplete handles an incomplete join depending on the mode.
+func (b *joinBehavior) emitIncomplete(key string, pj *pendingJoin) error {
+	switch b.mode {
+	case JoinLeftOuter:
+		if len(b.topics) == 0 {
+			return nil
+		}
+		if _, ok := pj.events[b.topics[0]]; ok {
+			return b.cell.EmitNew(TopicJoin, b.join(key, pj, false))
+		}
+	case JoinTimeoutEmitting:
+		return b.cell.EmitNew(TopicJoinTimeout, b.join(key, pj, false))
+	}
+	return nil
+}
+
+// join creates the join of the pending events.
+func (b *joinBehavior) join(key string, pj *pendingJoin, complete bool) Join {
+	j := Join{
+		Key:      key,
+		Payloads: make(map[string][]byte),
+		Complete: complete,
+	}
+	for topic, event := range pj.events {
+		j.Payloads[topic] = event.Payload().Bytes()
+	}
+	return j
+}
+
+// reset stops all timers and drops the pending joins.
+func (b *joinBehavior) reset() {
+	for _, pj := range b.pending {
+		pj.timer.Stop()
+	}
+	b.pending = make(map[string]*pendingJoin)
+}
+
+// EOF
@@ -0,0 +1,205 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Join
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInnerJoinBehavior tests the join behavior in inner mode.
+func TestInnerJoinBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("inner-join-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		var je joinEvent
+		err := event.Payload().Unmarshal(&je)
+		return je.ID, err
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var join behaviors.Join
+		if err := event.Payload().Unmarshal(&join); err != nil {
+			return err
+		}
+		sigc <- event.Topic() + " " + joinString(join)
+		return nil
+	}
+
+	env.StartCell("joiner", behaviors.NewJoinBehavior(behaviors.JoinInner, key, 50*time.Millisecond, "order", "payment", "shipment"))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("joiner", "signaler")
+
+	emitJoinEvents(env)
+
+	assert.Wait(sigc, "join 1 true order/payment/shipment", time.Second)
+	// Incomplete ones are dropped.
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected join: %v", v))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestJoinBehaviorDuplicateTopics tests the join behavior
+// with a topic passed twice.
+func TestJoinBehaviorDuplicateTopics(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("join-behavior-duplicate-topics")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		var je joinEvent
+		err := event.Payload().Unmarshal(&je)
+		return je.ID, err
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var join behaviors.Join
+		if err := event.Payload().Unmarshal(&join); err != nil {
+			return err
+		}
+		sigc <- event.Topic() + " " + joinString(join)
+		return nil
+	}
+
+	env.StartCell("joiner", behaviors.NewJoinBehavior(behaviors.JoinTimeoutEmitting, key, time.Second, "order", "payment", "order", "shipment"))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("joiner", "signaler")
+
+	emitJoinEvents(env)
+
+	assert.Wait(sigc, "join 1 true order/payment/shipment", 500*time.Millisecond)
+}
+
+// TestLeftOuterJoinBehavior tests the join behavior in left outer mode.
+func TestLeftOuterJoinBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("left-outer-join-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		var je joinEvent
+		err := event.Payload().Unmarshal(&je)
+		return je.ID, err
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var join behaviors.Join
+		if err := event.Payload().Unmarshal(&join); err != nil {
+			return err
+		}
+		sigc <- event.Topic() + " " + joinString(join)
+		return nil
+	}
+
+	env.StartCell("joiner", behaviors.NewJoinBehavior(behaviors.JoinLeftOuter, key, 50*time.Millisecond, "order", "payment", "shipment"))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("joiner", "signaler")
+
+	emitJoinEvents(env)
+
+	assert.Wait(sigc, "join 1 true order/payment/shipment", time.Second)
+	assert.Wait(sigc, "join 2 false order/payment", time.Second)
+}
+
+// TestTimeoutEmittingJoinBehavior tests the join behavior in timeout
+// emitting mode.
+func TestTimeoutEmittingJoinBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("timeout-emitting-join-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		var je joinEvent
+		err := event.Payload().Unmarshal(&je)
+		return je.ID, err
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var join behaviors.Join
+		if err := event.Payload().Unmarshal(&join); err != nil {
+			return err
+		}
+		sigc <- event.Topic() + " " + joinString(join)
+		return nil
+	}
+
+	env.StartCell("joiner", behaviors.NewJoinBehavior(behaviors.JoinTimeoutEmitting, key, 50*time.Millisecond, "order", "payment", "shipment"))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("joiner", "signaler")
+
+	emitJoinEvents(env)
+
+	assert.Wait(sigc, "join 1 true order/payment/shipment", time.Second)
+	received := map[interface{}]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.True(received["join:timeout 2 false order/payment"])
+	assert.True(received["join:timeout 3 false payment"])
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// joinEvent is the payload of the events to join.
+type joinEvent struct {
+	ID string
+}
+
+// emitJoinEvents emits one complete set of events with ID 1
+// and incomplete sets with the IDs 2 and 3.
+func emitJoinEvents(env cells.Environment) {
+	env.EmitNew("joiner", "order", joinEvent{"1"})
+	env.EmitNew("joiner", "order", joinEvent{"2"})
+	env.EmitNew("joiner", "payment", joinEvent{"2"})
+	env.EmitNew("joiner", "payment", joinEvent{"1"})
+	env.EmitNew("joiner", "payment", joinEvent{"3"})
+	env.EmitNew("joiner", "ignored", joinEvent{"1"})
+	env.EmitNew("joiner", "shipment", joinEvent{"1"})
+}
+
+// joinString returns the key, completeness, and joined
+// topics of a join.
+func joinString(join behaviors.Join) string {
+	topics := []string{}
+	for _, topic := range []string{"order", "payment", "shipment"} {
+		if payload, ok := join.Payload(topic); ok {
+			var je joinEvent
+			if err := payload.Unmarshal(&je); err != nil || je.ID != join.Key {
+				return fmt.Sprintf("invalid payload %v", payload)
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return fmt.Sprintf("%s %v %s", join.Key, join.Complete, strings.Join(topics, "/"))
+}
+
+// EOF
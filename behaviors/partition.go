@@ -0,0 +1,305 @@
+// Tideland Go Cells - Behaviors - Partition
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicPartitionEvict is used internally to evict idle partitions.
+	TopicPartitionEvict = "partition:evict"
+
+	// TopicPartitionEvent is used internally to route the events a
+	// partition emits to itself.
+	TopicPartitionEvent = "partition:event"
+)
+
+//--------------------
+// PARTITION BEHAVIOR
+//--------------------
+
+// PartitionKey returns the key of the partition an event is routed to.
+type PartitionKey func(event cells.Event) (string, error)
+
+// PartitionFactory creates the behavior for a new partition.
+type PartitionFactory func(key string) cells.Behavior
+
+// partitionCell is the cell facade passed to the behavior of a
+// partition. It emits to the subscribers of the partitioning cell,
+// but has an own ID and environment.
+type partitionCell struct {
+	cells.Cell
+	env *partitionEnvironment
+}
+
+// ID implements the cells.Cell interface.
+func (c *partitionCell) ID() string {
+	return c.env.id
+}
+
+// Environment implements the cells.Cell interface.
+func (c *partitionCell) Environment() cells.Environment {
+	return c.env
+}
+
+// partitionEnvironment is the environment facade of a partition. Events
+// emitted to the ID of the partition are routed to it via the partitioning
+// cell, e.g. the timer events of behaviors.
+type partitionEnvironment struct {
+	cells.Environment
+	cellID string
+	id     string
+	key    string
+}
+
+// partitionEvent wraps an event routed to a partition. The original
+// event is kept, so that its timestamp, deadline, hops, and payload
+// are passed unchanged.
+type partitionEvent struct {
+	cells.Event
+	key string
+}
+
+// Topic implements the cells.Event interface.
+func (e *partitionEvent) Topic() string {
+	return TopicPartitionEvent
+}
+
+// route wraps events emitted to the partition itself.
+func (e *partitionEnvironment) route(id string, event cells.Event) (string, cells.Event) {
+	if id != e.id {
+		return id, event
+	}
+	return e.cellID, &partitionEvent{
+		Event: event,
+		key:   e.key,
+	}
+}
+
+// Emit implements the cells.Environment interface.
+func (e *partitionEnvironment) Emit(id string, event cells.Event) error {
+	id, event = e.route(id, event)
+	return e.Environment.Emit(id, event)
+}
+
+// EmitNew implements the cells.Environment interface.
+func (e *partitionEnvironment) EmitNew(id, topic string, payload interface{}) error {
+	event, err := cells.NewEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+	return e.Emit(id, event)
+}
+
+// EmitAt implements the cells.Environment interface.
+func (e *partitionEnvironment) EmitAt(id string, at time.Time, event cells.Event) (cells.Scheduled, error) {
+	id, event = e.route(id, event)
+	return e.Environment.EmitAt(id, at, event)
+}
+
+// EmitAfter implements the cells.Environment interface.
+func (e *partitionEnvironment) EmitAfter(id string, after time.Duration, event cells.Event) (cells.Scheduled, error) {
+	id, event = e.route(id, event)
+	return e.Environment.EmitAfter(id, after, event)
+}
+
+// partition contains one behavior instance and its last activity.
+type partition struct {
+	behavior cells.Behavior
+	last     time.Time
+}
+
+// partitionBehavior routes events to one behavior instance per key.
+type partitionBehavior struct {
+	cell       cells.Cell
+	key        PartitionKey
+	create     PartitionFactory
+	ttl        time.Duration
+	partitions map[string]*partition
+	current    *string
+	loop       loop.Loop
+}
+
+// NewPartitionBehavior creates a behavior routing each event to a
+// partition determined by the key function. For each new key the
+// factory creates a behavior which is initialized with a cell facade.
+// Events it emits are received by the subscribers of the partitioning
+// cell. If the ttl is larger than 0 partitions not receiving events
+// during this duration are evicted and terminated.
+//
+// The facade has an own ID and environment. Events the behavior emits
+// to this ID, e.g. its timer events, are routed directly to its
+// partition.
+//
+// Errors or panics during the processing of an event are recovered by
+// the behavior of the partition. If this fails the partition is dropped.
+// Events whose key cannot be determined or whose new partition fails to
+// initialize are logged and dropped.
+func NewPartitionBehavior(key PartitionKey, factory PartitionFactory, ttl time.Duration) cells.Behavior {
+	return &partitionBehavior{
+		key:        key,
+		create:     factory,
+		ttl:        ttl,
+		partitions: make(map[string]*partition),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *partitionBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	if b.ttl > 0 {
+		b.loop = loop.Go(b.evictLoop)
+	}
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *partitionBehavior) Terminate() error {
+	var errs []error
+	if b.loop != nil {
+		if err := b.loop.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for key := range b.partitions {
+		if err := b.drop(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Collect(errs...)
+	}
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *partitionBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicPartitionEvict:
+		now := time.Now()
+		for key, p := range b.partitions {
+			if now.Sub(p.last) >= b.ttl {
+				if err := b.drop(key); err != nil {
+					logger.Errorf("partition %q of cell %q terminated with error: %v", key, b.cell.ID(), err)
+				}
+			}
+		}
+	case TopicPartitionEvent:
+		pe, ok := event.(*partitionEvent)
+		if !ok {
+			// E.g. restored from a snapshot without the key.
+			return nil
+		}
+		if _, ok := b.partitions[pe.key]; !ok {
+			// Partition has been evicted meanwhile.
+			return nil
+		}
+		return b.process(pe.key, pe.Event)
+	default:
+		key, err := b.key(event)
+		if err != nil {
+			logger.Errorf("cell %q cannot determine partition of event %q: %v", b.cell.ID(), event.Topic(), err)
+			return nil
+		}
+		if _, ok := b.partitions[key]; !ok {
+			behavior := b.create(key)
+			env := &partitionEnvironment{
+				Environment: b.cell.Environment(),
+				cellID:      b.cell.ID(),
+				id:          b.cell.ID() + cells.GroupSeparator + key,
+				key:         key,
+			}
+			if err := behavior.Init(&partitionCell{b.cell, env}); err != nil {
+				logger.Errorf("partition %q of cell %q cannot be initialized: %v", key, b.cell.ID(), err)
+				return nil
+			}
+			b.partitions[key] = &partition{
+				behavior: behavior,
+			}
+		}
+		return b.process(key, event)
+	}
+	return nil
+}
+
+// process lets the partition with the key process the event.
+func (b *partitionBehavior) process(key string, event cells.Event) error {
+	p := b.partitions[key]
+	p.last = time.Now()
+	// Keep the key in case of a needed recovering.
+	b.current = &key
+	if err := p.behavior.ProcessEvent(event); err != nil {
+		return err
+	}
+	b.current = nil
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *partitionBehavior) Recover(err interface{}) error {
+	if b.current == nil {
+		return nil
+	}
+	key := *b.current
+	b.current = nil
+	p, ok := b.partitions[key]
+	if !ok {
+		return nil
+	}
+	if rerr := p.behavior.Recover(err); rerr != nil {
+		logger.Errorf("partition %q of cell %q cannot recover: %v", key, b.cell.ID(), rerr)
+		b.drop(key)
+	}
+	return nil
+}
+
+// drop terminates and removes a partition.
+func (b *partitionBehavior) drop(key string) error {
+	p := b.partitions[key]
+	delete(b.partitions, key)
+	return p.behavior.Terminate()
+}
+
+// evictLoop regularly tells the cell to evict idle partitions.
+func (b *partitionBehavior) evictLoop(l loop.Loop) error {
+	interval := b.ttl / 2
+	if interval <= 0 {
+		interval = b.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case <-ticker.C:
+			// Notify myself, act there to avoid
+			// race with the processing.
+			if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicPartitionEvict, nil); err != nil {
+				logger.Errorf("partitions of cell %q cannot be evicted: %v", b.cell.ID(), err)
+			}
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,254 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Partition
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPartitionBehavior tests the partition behavior.
+func TestPartitionBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := audit.MakeSigChan()
+	env := cells.NewEnvironment("partition-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	factory := func(key string) cells.Behavior {
+		count := 0
+		return behaviors.NewSimpleProcessorBehavior(func(cell cells.Cell, event cells.Event) error {
+			if event.Topic() == "panic" {
+				panic("ouch")
+			}
+			count++
+			return cell.EmitNew("counted", fmt.Sprintf("%s:%d", key, count))
+		})
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("partitioner", behaviors.NewPartitionBehavior(key, factory, 0))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("partitioner", "signaler")
+
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:1", time.Second)
+	env.EmitNew("partitioner", "order", "b")
+	assert.Wait(sigc, "b:1", time.Second)
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:2", time.Second)
+	env.EmitNew("partitioner", "panic", "a")
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:3", time.Second)
+	env.EmitNew("partitioner", "order", "b")
+	assert.Wait(sigc, "b:2", time.Second)
+}
+
+// TestPartitionBehaviorEviction tests the eviction of idle partitions.
+func TestPartitionBehaviorEviction(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := audit.MakeSigChan()
+	env := cells.NewEnvironment("partition-behavior-eviction")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	factory := func(key string) cells.Behavior {
+		count := 0
+		return behaviors.NewSimpleProcessorBehavior(func(cell cells.Cell, event cells.Event) error {
+			count++
+			return cell.EmitNew("counted", fmt.Sprintf("%s:%d", key, count))
+		})
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("partitioner", behaviors.NewPartitionBehavior(key, factory, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("partitioner", "signaler")
+
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:1", time.Second)
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:2", time.Second)
+
+	time.Sleep(200 * time.Millisecond)
+
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:1", time.Second)
+}
+
+// TestPartitionBehaviorFailures tests that events whose key or
+// partition fail are dropped without recovering the cell.
+func TestPartitionBehaviorFailures(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := audit.MakeSigChan()
+	env := cells.NewEnvironment("partition-behavior-failures")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		if event.Topic() == "no-key" {
+			return "", errors.New("no key")
+		}
+		return event.Payload().String(), nil
+	}
+	factory := func(key string) cells.Behavior {
+		if key == "broken" {
+			return &failingInitBehavior{}
+		}
+		return behaviors.NewSimpleProcessorBehavior(func(cell cells.Cell, event cells.Event) error {
+			return cell.EmitNew("processed", key)
+		})
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("partitioner", behaviors.NewPartitionBehavior(key, factory, 0))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("partitioner", "signaler")
+	lifecyclec := make(chan interface{}, 10)
+	defer env.ObserveLifecycle(func(le cells.LifecycleEvent) {
+		if le.Kind == cells.CellRecovering || le.Kind == cells.CellStarted {
+			lifecyclec <- le.String()
+		}
+	})()
+
+	env.EmitNew("partitioner", "no-key", "a")
+	env.EmitNew("partitioner", "order", "broken")
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a", time.Second)
+
+	// Lifecycle events are passed in order, so the partitioner
+	// would have been recovering before the marker started.
+	env.StartCell("marker", behaviors.NewSimpleProcessorBehavior(signaler))
+	assert.Wait(lifecyclec, `<cell "marker" started>`, time.Second)
+}
+
+// TestPartitionBehaviorSelfEmit tests partitions emitting
+// events to themselves like timers do.
+func TestPartitionBehaviorSelfEmit(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("partition-behavior-self-emit")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	factory := func(key string) cells.Behavior {
+		return behaviors.NewDebounceBehavior(50*time.Millisecond, behaviors.DebounceTrailing)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("partitioner", behaviors.NewPartitionBehavior(key, factory, 0))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("partitioner", "signaler")
+
+	env.EmitNew("partitioner", "click", "a")
+	env.EmitNew("partitioner", "click", "b")
+	env.EmitNew("partitioner", "click", "a")
+
+	// Each partition emits its last event after the quiet period.
+	received := map[interface{}]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Equal(received, map[interface{}]bool{"a": true, "b": true})
+}
+
+// TestPartitionBehaviorSelfEmitEvent tests that events partitions
+// emit to themselves are passed unchanged.
+func TestPartitionBehaviorSelfEmitEvent(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := audit.MakeSigChan()
+	env := cells.NewEnvironment("partition-behavior-self-emit-event")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	factory := func(key string) cells.Behavior {
+		return behaviors.NewSimpleProcessorBehavior(func(cell cells.Cell, event cells.Event) error {
+			switch event.Topic() {
+			case "order":
+				self, err := cells.NewEventWithTTL("self", cells.NewTypedPayload([]string{key}), time.Minute)
+				if err != nil {
+					return err
+				}
+				return cell.Environment().Emit(cell.ID(), self)
+			case "self":
+				keys, err := cells.PayloadAs[[]string](event.Payload())
+				if err != nil {
+					return err
+				}
+				_, ok := event.Deadline()
+				return cell.EmitNew("self", fmt.Sprintf("%s:%v", keys[0], ok))
+			}
+			return nil
+		})
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("partitioner", behaviors.NewPartitionBehavior(key, factory, 0))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("partitioner", "signaler")
+
+	env.EmitNew("partitioner", "order", "a")
+	assert.Wait(sigc, "a:true", time.Second)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// failingInitBehavior is a behavior which cannot be initialized.
+type failingInitBehavior struct{}
+
+func (b *failingInitBehavior) Init(c cells.Cell) error { return errors.New("cannot init") }
+
+func (b *failingInitBehavior) Terminate() error { return nil }
+
+func (b *failingInitBehavior) ProcessEvent(event cells.Event) error { return nil }
+
+func (b *failingInitBehavior) Recover(r interface{}) error { return nil }
+
+// EOF
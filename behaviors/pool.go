@@ -0,0 +1,206 @@
+// Tideland Go Cells - Behaviors - Pool
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// POOL BEHAVIOR
+//--------------------
+
+// PoolProcessor is a function processing an event inside one of the
+// workers of a pool. A returned event is emitted, nil is allowed.
+type PoolProcessor func(event cells.Event) (cells.Event, error)
+
+// PoolKey returns the key of an event. Events with the same key are
+// processed by the same worker in the order they have been received.
+type PoolKey func(event cells.Event) (string, error)
+
+// poolJob is one event to process by a worker.
+type poolJob struct {
+	sequence uint64
+	event    cells.Event
+}
+
+// poolBehavior processes events concurrently in a number of workers.
+type poolBehavior struct {
+	cell     cells.Cell
+	workers  int
+	process  PoolProcessor
+	key      PoolKey
+	ordered  bool
+	jobcs    []chan poolJob
+	resultc  chan poolJob
+	slots    chan struct{}
+	sequence uint64
+	wg       sync.WaitGroup
+	loop     loop.Loop
+}
+
+// NewPoolBehavior creates a behavior running the processor concurrently
+// in the given number of workers. If the key function is not nil all
+// events with the same key are processed by the same worker in the order
+// they have been received. The events returned by the processor are
+// emitted in the order the workers complete them. If ordered is true
+// they are emitted in the order the original events have been received.
+// Here not more events than workers are processed or wait for their
+// predecessors at the same time.
+// If the workers are busy and their queues are full the processing of
+// the cell blocks until a worker takes the next event.
+func NewPoolBehavior(workers int, processor PoolProcessor, key PoolKey, ordered bool) cells.Behavior {
+	if workers < 1 {
+		workers = 1
+	}
+	return &poolBehavior{
+		workers: workers,
+		process: processor,
+		key:     key,
+		ordered: ordered,
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *poolBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	b.jobcs = make([]chan poolJob, b.workers)
+	b.resultc = make(chan poolJob, b.workers)
+	shared := make(chan poolJob, b.workers)
+	if b.ordered {
+		b.slots = make(chan struct{}, b.workers)
+	}
+	for i := range b.jobcs {
+		if b.key == nil {
+			// All workers take from the same channel.
+			b.jobcs[i] = shared
+		} else {
+			b.jobcs[i] = make(chan poolJob, b.workers)
+		}
+		b.wg.Add(1)
+		go b.work(b.jobcs[i])
+	}
+	b.loop = loop.Go(b.emitLoop)
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface. It waits
+// until all pending events are processed.
+func (b *poolBehavior) Terminate() error {
+	if b.key == nil {
+		close(b.jobcs[0])
+	} else {
+		for _, jobc := range b.jobcs {
+			close(jobc)
+		}
+	}
+	b.wg.Wait()
+	close(b.resultc)
+	return b.loop.Wait()
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *poolBehavior) ProcessEvent(event cells.Event) error {
+	index := 0
+	if b.key != nil {
+		key, err := b.key(event)
+		if err != nil {
+			return err
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		index = int(h.Sum32() % uint32(b.workers))
+	}
+	if b.slots != nil {
+		// Wait until the reorder buffer has room.
+		b.slots <- struct{}{}
+	}
+	b.sequence++
+	b.jobcs[index] <- poolJob{b.sequence, event}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *poolBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// work processes the jobs of one worker.
+func (b *poolBehavior) work(jobc <-chan poolJob) {
+	defer b.wg.Done()
+	for job := range jobc {
+		b.resultc <- poolJob{job.sequence, b.safeProcess(job.event)}
+	}
+}
+
+// safeProcess calls the processor and logs errors and panics.
+func (b *poolBehavior) safeProcess(event cells.Event) (result cells.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("pool of cell %q panicked processing event %q: %v", b.cell.ID(), event.Topic(), r)
+			result = nil
+		}
+	}()
+	result, err := b.process(event)
+	if err != nil {
+		logger.Errorf("pool of cell %q processed event %q with error: %v", b.cell.ID(), event.Topic(), err)
+		return nil
+	}
+	return result
+}
+
+// emitLoop emits the results of the workers.
+func (b *poolBehavior) emitLoop(l loop.Loop) error {
+	pending := make(map[uint64]cells.Event)
+	next := uint64(1)
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case result, ok := <-b.resultc:
+			if !ok {
+				return nil
+			}
+			if !b.ordered {
+				b.emit(result.event)
+				continue
+			}
+			// Emit all results in sequence.
+			pending[result.sequence] = result.event
+			for {
+				event, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				b.emit(event)
+				<-b.slots
+			}
+		}
+	}
+}
+
+// emit emits a result if it is not nil.
+func (b *poolBehavior) emit(event cells.Event) {
+	if event != nil {
+		b.cell.Emit(event)
+	}
+}
+
+// EOF
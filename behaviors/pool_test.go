@@ -0,0 +1,245 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Pool
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPoolBehavior tests the pool behavior emitting in completion order.
+func TestPoolBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("pool-behavior")
+	defer env.Stop()
+
+	generator := audit.NewGenerator(audit.FixedRand())
+	durations := make([]time.Duration, 20)
+	for i := range durations {
+		durations[i] = time.Duration(generator.Int(10, 100)) * time.Millisecond
+	}
+	var running, maxRunning int64
+	processor := func(event cells.Event) (cells.Event, error) {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return nil, err
+		}
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			max := atomic.LoadInt64(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(durations[i])
+		return cells.NewEvent("worked", i)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return err
+		}
+		sigc <- i
+		return nil
+	}
+
+	env.StartCell("pool", behaviors.NewPoolBehavior(4, processor, nil, false))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("pool", "signaler")
+
+	for i := 0; i < 8; i++ {
+		env.EmitNew("pool", "work", i)
+	}
+
+	received := map[int]bool{}
+	for i := 0; i < 8; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v.(int)] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Length(received, 8)
+	// Workers run concurrently, but not more than four.
+	max := atomic.LoadInt64(&maxRunning)
+	assert.True(max > 1)
+	assert.True(max <= 4)
+}
+
+// TestOrderedPoolBehavior tests the pool behavior emitting in input order.
+func TestOrderedPoolBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("ordered-pool-behavior")
+	defer env.Stop()
+
+	generator := audit.NewGenerator(audit.FixedRand())
+	durations := make([]time.Duration, 20)
+	for i := range durations {
+		durations[i] = time.Duration(generator.Int(10, 100)) * time.Millisecond
+	}
+	processor := func(event cells.Event) (cells.Event, error) {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return nil, err
+		}
+		time.Sleep(durations[i])
+		return cells.NewEvent("worked", i)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return err
+		}
+		sigc <- i
+		return nil
+	}
+
+	env.StartCell("pool", behaviors.NewPoolBehavior(4, processor, nil, true))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("pool", "signaler")
+
+	for i := 0; i < 20; i++ {
+		env.EmitNew("pool", "work", i)
+	}
+	for i := 0; i < 20; i++ {
+		assert.Wait(sigc, i, time.Second)
+	}
+}
+
+// TestOrderedPoolBehaviorLimit tests that a slow event limits the
+// number of events processed and buffered after it.
+func TestOrderedPoolBehaviorLimit(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("ordered-pool-behavior-limit")
+	defer env.Stop()
+
+	var started int64
+	startedc := make(chan interface{}, 20)
+	releasec := make(chan struct{})
+	processor := func(event cells.Event) (cells.Event, error) {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return nil, err
+		}
+		startedc <- atomic.AddInt64(&started, 1)
+		if i == 0 {
+			select {
+			case <-releasec:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return cells.NewEvent("worked", i)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return err
+		}
+		sigc <- i
+		return nil
+	}
+
+	env.StartCell("pool", behaviors.NewPoolBehavior(4, processor, nil, true))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("pool", "signaler")
+
+	for i := 0; i < 20; i++ {
+		env.EmitNew("pool", "work", i)
+	}
+
+	// While the first event is blocked only the
+	// following three ones are processed.
+	for i := 1; i <= 4; i++ {
+		assert.Wait(startedc, int64(i), time.Second)
+	}
+	select {
+	case v := <-startedc:
+		assert.Fail(fmt.Sprintf("unexpected processing: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(releasec)
+	for i := 0; i < 20; i++ {
+		assert.Wait(sigc, i, time.Second)
+	}
+}
+
+// TestKeyedPoolBehavior tests the pool behavior keeping the order per key.
+func TestKeyedPoolBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 20)
+	env := cells.NewEnvironment("keyed-pool-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		var i int
+		err := event.Payload().Unmarshal(&i)
+		if i%2 == 0 {
+			return "even", err
+		}
+		return "odd", err
+	}
+	generator := audit.NewGenerator(audit.FixedRand())
+	durations := make([]time.Duration, 20)
+	for i := range durations {
+		durations[i] = time.Duration(generator.Int(10, 100)) * time.Millisecond
+	}
+	processor := func(event cells.Event) (cells.Event, error) {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return nil, err
+		}
+		time.Sleep(durations[i])
+		return cells.NewEvent("worked", i)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var i int
+		if err := event.Payload().Unmarshal(&i); err != nil {
+			return err
+		}
+		sigc <- i
+		return nil
+	}
+
+	env.StartCell("pool", behaviors.NewPoolBehavior(4, processor, key, false))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("pool", "signaler")
+
+	for i := 0; i < 20; i++ {
+		env.EmitNew("pool", "work", i)
+	}
+
+	lasts := map[int]int{0: -2, 1: -1}
+	for i := 0; i < 20; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			i := v.(int)
+			assert.Equal(lasts[i%2]+2, i)
+			lasts[i%2] = i
+			return nil
+		}, time.Second)
+	}
+}
+
+// EOF
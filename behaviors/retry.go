@@ -0,0 +1,226 @@
+// Tideland Go Cells - Behaviors - Retry
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicRetryFailed is used for the events which could not be
+	// processed after all attempts.
+	TopicRetryFailed = "retry:failed"
+
+	// TopicRetryAttempt is used internally to start the next
+	// attempt of a pending event.
+	TopicRetryAttempt = "retry:attempt"
+)
+
+//--------------------
+// RETRY BEHAVIOR
+//--------------------
+
+// RetryPolicy defines how often and when failed events are retried.
+// The delay before the second attempt is the backoff, it doubles with
+// each further attempt up to the maximum backoff if it is greater than
+// 0. The jitter between 0.0 and 1.0 reduces each delay randomly by up
+// to this fraction. Blocking retries hold back all following events
+// until the event is processed or has failed, so the order is kept.
+// Stopping the cell ends their waiting.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+	Blocking   bool
+}
+
+// RetryFailure is emitted with the topic "retry:failed" when an event
+// still fails after all attempts.
+type RetryFailure struct {
+	Topic    string
+	Data     []byte
+	Attempts int
+	Error    string
+}
+
+// Payload returns the payload of the failed event.
+func (f RetryFailure) Payload() (cells.Payload, error) {
+	return cells.NewPayload(f.Data)
+}
+
+// pendingRetry is an event waiting for its next attempt.
+type pendingRetry struct {
+	event   cells.Event
+	attempt int
+	timer   *time.Timer
+}
+
+// retryBehavior retries failed events of a processor.
+type retryBehavior struct {
+	cell    cells.Cell
+	process SimpleProcessor
+	policy  RetryPolicy
+	rand    *rand.Rand
+	pending map[int]*pendingRetry
+	counter int
+}
+
+// NewRetryBehavior creates a behavior passing the received events to the
+// processor. If it returns an error the event is retried according to the
+// policy. Non-blocking retries are scheduled via the queue of the cell, so
+// other events are processed in the meantime. After the last failed attempt
+// a RetryFailure is emitted. A "reset" drops all pending retries.
+func NewRetryBehavior(processor SimpleProcessor, policy RetryPolicy) cells.Behavior {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	if policy.Jitter < 0.0 {
+		policy.Jitter = 0.0
+	}
+	if policy.Jitter > 1.0 {
+		policy.Jitter = 1.0
+	}
+	return &retryBehavior{
+		process: processor,
+		policy:  policy,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		pending: make(map[int]*pendingRetry),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *retryBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *retryBehavior) Terminate() error {
+	b.dropPending()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *retryBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicRetryAttempt:
+		var id int
+		if err := event.Payload().Unmarshal(&id); err != nil {
+			return err
+		}
+		pr, ok := b.pending[id]
+		if !ok {
+			return nil
+		}
+		delete(b.pending, id)
+		return b.attempt(pr.event, pr.attempt)
+	case cells.TopicReset:
+		b.dropPending()
+	default:
+		return b.attempt(event, 1)
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *retryBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// attempt processes the event starting with the given attempt.
+func (b *retryBehavior) attempt(event cells.Event, attempt int) error {
+	for {
+		err := b.process(b.cell, event)
+		if err == nil {
+			return nil
+		}
+		if attempt >= b.policy.Attempts {
+			return b.cell.EmitNew(TopicRetryFailed, RetryFailure{
+				Topic:    event.Topic(),
+				Data:     event.Payload().Bytes(),
+				Attempts: attempt,
+				Error:    err.Error(),
+			})
+		}
+		delay := b.delay(attempt)
+		attempt++
+		if b.policy.Blocking {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+				continue
+			case <-b.cell.Context().Done():
+				// Cell is stopping.
+				timer.Stop()
+				return nil
+			}
+		}
+		// Schedule the next attempt.
+		b.counter++
+		id := b.counter
+		b.pending[id] = &pendingRetry{
+			event:   event,
+			attempt: attempt,
+			timer: time.AfterFunc(delay, func() {
+				if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicRetryAttempt, id); err != nil {
+					logger.Errorf("retry of cell %q cannot be attempted: %v", b.cell.ID(), err)
+				}
+			}),
+		}
+		return nil
+	}
+}
+
+// delay calculates the delay after the given attempt. Without
+// a maximum backoff it saturates instead of overflowing.
+func (b *retryBehavior) delay(attempt int) time.Duration {
+	maxBackoff := b.policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Duration(math.MaxInt64)
+	}
+	delay := b.policy.Backoff
+	for i := 1; i < attempt; i++ {
+		if delay > maxBackoff/2 {
+			delay = maxBackoff
+			break
+		}
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if b.policy.Jitter > 0.0 {
+		delay -= time.Duration(b.rand.Float64() * b.policy.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// dropPending stops the timers of all pending retries.
+func (b *retryBehavior) dropPending() {
+	for id, pr := range b.pending {
+		pr.timer.Stop()
+		delete(b.pending, id)
+	}
+}
+
+// EOF
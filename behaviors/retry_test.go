@@ -0,0 +1,183 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Retry
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRetryBehavior tests the retry behavior not blocking
+// other events.
+func TestRetryBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("retry-behavior")
+	defer env.Stop()
+
+	policy := behaviors.RetryPolicy{
+		Attempts: 3,
+		Backoff:  20 * time.Millisecond,
+		Jitter:   0.5,
+	}
+	failures := map[string]int{}
+	processor := func(cell cells.Cell, event cells.Event) error {
+		var n int
+		if err := event.Payload().Unmarshal(&n); err != nil {
+			return err
+		}
+		key := event.Payload().String()
+		if failures[key] < n {
+			failures[key]++
+			return errors.New("failing")
+		}
+		return cell.EmitNew("processed", n)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicRetryFailed:
+			var failure behaviors.RetryFailure
+			if err := event.Payload().Unmarshal(&failure); err != nil {
+				return err
+			}
+			payload, err := failure.Payload()
+			if err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("failed %s %s after %d: %s", failure.Topic, payload, failure.Attempts, failure.Error)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	env.StartCell("retry", behaviors.NewRetryBehavior(processor, policy))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("retry", "signaler")
+
+	// Succeeds with the third attempt.
+	env.EmitNew("retry", "call", 2)
+	// Never succeeds.
+	env.EmitNew("retry", "call", 5)
+	env.EmitNew("retry", "call", 0)
+
+	assert.Wait(sigc, "processed 0", time.Second)
+	// Order depends on the jitter.
+	received := map[interface{}]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.True(received["processed 2"])
+	assert.True(received["failed call 5 after 3: failing"])
+}
+
+// TestRetryBehaviorBlocking tests the retry behavior keeping
+// the order of the events.
+func TestRetryBehaviorBlocking(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("retry-behavior-blocking")
+	defer env.Stop()
+
+	policy := behaviors.RetryPolicy{
+		Attempts:   4,
+		Backoff:    10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+		Blocking:   true,
+	}
+	failures := map[string]int{}
+	processor := func(cell cells.Cell, event cells.Event) error {
+		var n int
+		if err := event.Payload().Unmarshal(&n); err != nil {
+			return err
+		}
+		key := event.Payload().String()
+		if failures[key] < n {
+			failures[key]++
+			return errors.New("failing")
+		}
+		return cell.EmitNew("processed", n)
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		switch event.Topic() {
+		case behaviors.TopicRetryFailed:
+			var failure behaviors.RetryFailure
+			if err := event.Payload().Unmarshal(&failure); err != nil {
+				return err
+			}
+			payload, err := failure.Payload()
+			if err != nil {
+				return err
+			}
+			sigc <- fmt.Sprintf("failed %s %s after %d: %s", failure.Topic, payload, failure.Attempts, failure.Error)
+		default:
+			sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		}
+		return nil
+	}
+
+	env.StartCell("retry", behaviors.NewRetryBehavior(processor, policy))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("retry", "signaler")
+
+	env.EmitNew("retry", "call", 3)
+	env.EmitNew("retry", "call", 0)
+
+	assert.Wait(sigc, "processed 3", time.Second)
+	assert.Wait(sigc, "processed 0", time.Second)
+}
+
+// TestRetryBehaviorBlockingStop tests stopping the retry behavior
+// while it is waiting for the next attempt.
+func TestRetryBehaviorBlockingStop(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := audit.MakeSigChan()
+	env := cells.NewEnvironment("retry-behavior-blocking-stop")
+	defer env.Stop()
+
+	policy := behaviors.RetryPolicy{
+		Attempts: 40,
+		Backoff:  time.Hour,
+		Blocking: true,
+	}
+	processor := func(cell cells.Cell, event cells.Event) error {
+		sigc <- true
+		return errors.New("failing")
+	}
+
+	env.StartCell("retry", behaviors.NewRetryBehavior(processor, policy))
+
+	env.EmitNew("retry", "call", 1)
+	assert.Wait(sigc, true, time.Second)
+
+	stoppedc := audit.MakeSigChan()
+	go func() {
+		stoppedc <- env.StopCell("retry")
+	}()
+	assert.Wait(stoppedc, nil, time.Second)
+}
+
+// EOF
@@ -58,4 +58,39 @@ func TestRoundRobinBehavior(t *testing.T) {
 	}
 }
 
+// TestRoundRobinBehaviorCycle tests that the maximum hops
+// stop events circulating through round robin cells.
+func TestRoundRobinBehaviorCycle(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("round-robin-behavior-cycle")
+	defer env.Stop()
+
+	deadLetters := func(cell cells.Cell, event cells.Event) error {
+		var dl cells.DeadLetter
+		if err := event.Payload().Unmarshal(&dl); err != nil {
+			return err
+		}
+		sigc <- dl.Reason + " " + dl.CellID
+		return nil
+	}
+
+	env.StartCell("round-robin-1", behaviors.NewRoundRobinBehavior())
+	env.StartCell("round-robin-2", behaviors.NewRoundRobinBehavior())
+	env.StartCell("dead-letters", behaviors.NewSimpleProcessorBehavior(deadLetters))
+	env.Subscribe("round-robin-1", "round-robin-2")
+	env.Subscribe("round-robin-2", "round-robin-1")
+	env.Configure(cells.MaxHops(5), cells.DeadLetters("dead-letters"))
+
+	env.EmitNew("round-robin-1", "round", nil)
+
+	// Hops: 1 0, 2 1, 1 2, 2 3, 1 4, 2 5, 1 6.
+	assert.Wait(sigc, "max-hops round-robin-1", time.Second)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected dead letter: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 // EOF
@@ -0,0 +1,205 @@
+// Tideland Go Cells - Behaviors - Scheduler
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicSchedulerConfigure is used to replace the schedules
+	// of a scheduler at runtime. The payload is a slice of
+	// schedules.
+	TopicSchedulerConfigure = "scheduler:configure"
+
+	// TopicSchedulerFire is used internally to fire a schedule.
+	TopicSchedulerFire = "scheduler:fire"
+)
+
+//--------------------
+// SCHEDULER BEHAVIOR
+//--------------------
+
+// Schedule defines an event emitted by the scheduler. The spec is
+// a cron expression as described at ParseCronSpec.
+type Schedule struct {
+	ID      string
+	Spec    string
+	Topic   string
+	Payload interface{}
+}
+
+// SchedulerStatus contains the next times of the schedules by ID.
+type SchedulerStatus map[string]time.Time
+
+// schedulerFire identifies the timer of a schedule.
+type schedulerFire struct {
+	ID         string
+	Generation int
+}
+
+// scheduled is one running schedule.
+type scheduled struct {
+	schedule Schedule
+	spec     *CronSpec
+	next     time.Time
+	timer    *time.Timer
+}
+
+// schedulerBehavior emits events based on cron expressions.
+type schedulerBehavior struct {
+	cell       cells.Cell
+	location   *time.Location
+	schedules  []Schedule
+	scheduled  map[string]*scheduled
+	generation int
+	now        func() time.Time
+}
+
+// NewSchedulerBehavior creates a behavior emitting the events of the
+// schedules at the times defined by their cron expressions in the given
+// location. If it is nil the local time is used. The schedules can be
+// replaced at runtime by the topic "scheduler:configure" and a slice of
+// schedules as payload. The topic "status" with a cell ID as payload emits
+// the SchedulerStatus to this cell. Times missed e.g. due to a clock jump
+// are fired only once.
+func NewSchedulerBehavior(location *time.Location, schedules ...Schedule) cells.Behavior {
+	if location == nil {
+		location = time.Local
+	}
+	return &schedulerBehavior{
+		location:  location,
+		schedules: schedules,
+		now:       time.Now,
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *schedulerBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return b.configure(b.schedules)
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *schedulerBehavior) Terminate() error {
+	b.stop()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *schedulerBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicSchedulerFire:
+		var fire schedulerFire
+		if err := event.Payload().Unmarshal(&fire); err != nil {
+			return err
+		}
+		s, ok := b.scheduled[fire.ID]
+		if !ok || fire.Generation != b.generation {
+			return nil
+		}
+		now := b.now()
+		due := !now.Before(s.next)
+		// Continue after now, so that times missed e.g. after a
+		// clock jump are not fired in a burst.
+		b.start(s, now)
+		if !due {
+			// Timer fired too early.
+			return nil
+		}
+		return b.cell.EmitNew(s.schedule.Topic, s.schedule.Payload)
+	case TopicSchedulerConfigure:
+		var schedules []Schedule
+		if err := event.Payload().Unmarshal(&schedules); err != nil {
+			return err
+		}
+		return b.configure(schedules)
+	case cells.TopicStatus:
+		statusCell := event.Payload().String()
+		status := SchedulerStatus{}
+		for id, s := range b.scheduled {
+			status[id] = s.next
+		}
+		b.cell.Environment().EmitNew(statusCell, b.cell.ID(), status)
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *schedulerBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// configure validates the schedules and replaces the running ones.
+func (b *schedulerBehavior) configure(schedules []Schedule) error {
+	all := make(map[string]*scheduled)
+	for _, schedule := range schedules {
+		if _, ok := all[schedule.ID]; ok || schedule.Topic == "" {
+			return errors.New(ErrInvalidSchedule, errorMessages, schedule.ID)
+		}
+		spec, err := ParseCronSpec(schedule.Spec)
+		if err != nil {
+			return errors.Annotate(err, ErrInvalidSchedule, errorMessages, schedule.ID)
+		}
+		all[schedule.ID] = &scheduled{
+			schedule: schedule,
+			spec:     spec,
+		}
+	}
+	b.stop()
+	b.schedules = schedules
+	b.scheduled = all
+	b.generation++
+	now := b.now()
+	for _, s := range b.scheduled {
+		b.start(s, now)
+	}
+	return nil
+}
+
+// start starts the timer for the next time of the schedule
+// after the passed time.
+func (b *schedulerBehavior) start(s *scheduled, after time.Time) {
+	s.next = s.spec.Next(after.In(b.location))
+	if s.next.IsZero() {
+		return
+	}
+	fire := schedulerFire{
+		ID:         s.schedule.ID,
+		Generation: b.generation,
+	}
+	s.timer = time.AfterFunc(s.next.Sub(b.now()), func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicSchedulerFire, fire); err != nil {
+			logger.Errorf("schedule %q of cell %q cannot be fired: %v", fire.ID, b.cell.ID(), err)
+		}
+	})
+}
+
+// stop stops the timers of all schedules.
+func (b *schedulerBehavior) stop() {
+	for _, s := range b.scheduled {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,246 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Scheduler
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/errors"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSchedulerBehavior tests the scheduler behavior.
+func TestSchedulerBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("scheduler-behavior")
+	defer env.Stop()
+
+	statusc := audit.MakeSigChan()
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.SchedulerStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status
+		return nil
+	}
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+
+	// Invalid schedules.
+	err := env.StartCell("invalid", behaviors.NewSchedulerBehavior(time.UTC, behaviors.Schedule{
+		ID:    "invalid",
+		Spec:  "* * *",
+		Topic: "invalid",
+	}))
+	assert.ErrorMatch(err, ".*schedule \"invalid\" is invalid.*")
+
+	err = env.StartCell("scheduler", behaviors.NewSchedulerBehavior(time.UTC, behaviors.Schedule{
+		ID:    "hourly",
+		Spec:  "0 * * * *",
+		Topic: "hourly",
+	}, behaviors.Schedule{
+		ID:      "daily",
+		Spec:    "0 0 * * *",
+		Topic:   "daily",
+		Payload: "midnight",
+	}))
+	assert.Nil(err)
+
+	now := time.Now().UTC()
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.WaitTested(statusc, func(v interface{}) error {
+		status := v.(behaviors.SchedulerStatus)
+		assert.Length(status, 2)
+		assert.Equal(status["hourly"].Minute(), 0)
+		assert.True(status["hourly"].Sub(now) <= time.Hour)
+		assert.Equal(status["daily"].Hour(), 0)
+		assert.True(status["daily"].Sub(now) <= 24*time.Hour)
+		return nil
+	}, time.Second)
+
+	// Reconfiguration.
+	env.EmitNew("scheduler", behaviors.TopicSchedulerConfigure, []behaviors.Schedule{{
+		ID:    "minutely",
+		Spec:  "* * * * *",
+		Topic: "minutely",
+	}})
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.WaitTested(statusc, func(v interface{}) error {
+		status := v.(behaviors.SchedulerStatus)
+		assert.Length(status, 1)
+		assert.True(status["minutely"].Sub(now) <= time.Minute)
+		return nil
+	}, time.Second)
+}
+
+// TestSchedulerBehaviorFire tests the firing of schedules.
+func TestSchedulerBehaviorFire(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("scheduler-behavior-fire")
+	defer env.Stop()
+
+	var mutex sync.Mutex
+	now := time.Date(2017, time.March, 1, 10, 59, 30, 0, time.UTC)
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	setClock := func(t time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = t
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		return nil
+	}
+	statusc := audit.MakeSigChan()
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.SchedulerStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status["hourly"].Format("15:04")
+		return nil
+	}
+	scheduler := behaviors.NewSchedulerBehavior(time.UTC, behaviors.Schedule{
+		ID:      "hourly",
+		Spec:    "0 * * * *",
+		Topic:   "hourly",
+		Payload: "tick",
+	})
+	behaviors.SetSchedulerClock(scheduler, clock)
+
+	env.StartCell("scheduler", scheduler)
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+	env.Subscribe("scheduler", "signaler")
+
+	// Firing too early is ignored.
+	env.EmitNew("scheduler", behaviors.TopicSchedulerFire, behaviors.SchedulerFire{"hourly", 1})
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.Wait(statusc, "11:00", time.Second)
+
+	// Firing in time emits.
+	setClock(time.Date(2017, time.March, 1, 11, 0, 0, 0, time.UTC))
+	env.EmitNew("scheduler", behaviors.TopicSchedulerFire, behaviors.SchedulerFire{"hourly", 1})
+	assert.Wait(sigc, "hourly tick", time.Second)
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.Wait(statusc, "12:00", time.Second)
+
+	// Firing of the previous generation is ignored.
+	env.EmitNew("scheduler", behaviors.TopicSchedulerConfigure, []behaviors.Schedule{{
+		ID:      "hourly",
+		Spec:    "0 * * * *",
+		Topic:   "hourly",
+		Payload: "tock",
+	}})
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.Wait(statusc, "12:00", time.Second)
+	setClock(time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC))
+	env.EmitNew("scheduler", behaviors.TopicSchedulerFire, behaviors.SchedulerFire{"hourly", 1})
+	env.EmitNew("scheduler", behaviors.TopicSchedulerFire, behaviors.SchedulerFire{"hourly", 2})
+	assert.Wait(sigc, "hourly tock", time.Second)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestSchedulerBehaviorClockJump tests that missed times
+// are fired only once.
+func TestSchedulerBehaviorClockJump(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("scheduler-behavior-clock-jump")
+	defer env.Stop()
+
+	var mutex sync.Mutex
+	now := time.Date(2017, time.March, 1, 10, 59, 30, 0, time.UTC)
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	setClock := func(t time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = t
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- fmt.Sprintf("%s %s", event.Topic(), event.Payload())
+		return nil
+	}
+	statusc := audit.MakeSigChan()
+	statusProcessor := func(event cells.Event) error {
+		var status behaviors.SchedulerStatus
+		if err := event.Payload().Unmarshal(&status); err != nil {
+			return err
+		}
+		statusc <- status["hourly"].Format("15:04")
+		return nil
+	}
+	scheduler := behaviors.NewSchedulerBehavior(time.UTC, behaviors.Schedule{
+		ID:      "hourly",
+		Spec:    "0 * * * *",
+		Topic:   "hourly",
+		Payload: "tick",
+	})
+	behaviors.SetSchedulerClock(scheduler, clock)
+
+	env.StartCell("scheduler", scheduler)
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.StartCell("status", behaviors.NewStatusBehavior(statusProcessor))
+	env.Subscribe("scheduler", "signaler")
+
+	// Jump over four hours, the schedule fires once
+	// and continues with the next hour.
+	setClock(time.Date(2017, time.March, 1, 15, 0, 30, 0, time.UTC))
+	env.EmitNew("scheduler", behaviors.TopicSchedulerFire, behaviors.SchedulerFire{"hourly", 1})
+	assert.Wait(sigc, "hourly tick", time.Second)
+	env.EmitNew("scheduler", cells.TopicStatus, "status")
+	assert.Wait(statusc, "16:00", time.Second)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestSchedulerBehaviorErrors tests the error codes of
+// invalid schedules.
+func TestSchedulerBehaviorErrors(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("scheduler-behavior-errors")
+	defer env.Stop()
+
+	err := env.StartCell("scheduler", behaviors.NewSchedulerBehavior(nil, behaviors.Schedule{
+		ID:   "no-topic",
+		Spec: "* * * * *",
+	}))
+	assert.True(errors.IsError(err, cells.ErrCellInit))
+}
+
+// EOF
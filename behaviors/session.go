@@ -0,0 +1,209 @@
+// Tideland Go Cells - Behaviors - Session
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicSession signals the processing result of a closed session.
+	TopicSession = "session"
+
+	// TopicSessionTimeout is used internally to check if a
+	// session has been idle long enough.
+	TopicSessionTimeout = "session:timeout"
+)
+
+//--------------------
+// SESSION BEHAVIOR
+//--------------------
+
+// SessionKey returns the key of the session an event belongs to.
+type SessionKey func(event cells.Event) (string, error)
+
+// sessionTimeout identifies a session to check.
+type sessionTimeout struct {
+	Key string
+	ID  int
+}
+
+// session contains the events of one key.
+type session struct {
+	id    int
+	sink  cells.EventSink
+	last  time.Time
+	timer *time.Timer
+}
+
+// sessionBehavior groups events into sessions per key.
+type sessionBehavior struct {
+	cell     cells.Cell
+	key      SessionKey
+	gap      time.Duration
+	max      int
+	process  cells.EventSinkProcessor
+	sessions map[string]*session
+	counter  int
+}
+
+// NewSessionBehavior creates a behavior grouping events into sessions. The
+// key function returns the session key of each event. A session is closed
+// once no event with its key has been received for the gap duration. Then
+// its events are passed to the processor and the returned payload is
+// emitted with the topic "session". If max is larger than 0 it limits the
+// number of concurrently open sessions. Here a new session closes the one
+// idling the longest time. A received "reset" as topic drops all sessions.
+func NewSessionBehavior(
+	key SessionKey,
+	gap time.Duration,
+	max int,
+	processor cells.EventSinkProcessor) cells.Behavior {
+	return &sessionBehavior{
+		key:      key,
+		gap:      gap,
+		max:      max,
+		process:  processor,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *sessionBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *sessionBehavior) Terminate() error {
+	b.reset()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *sessionBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicSessionTimeout:
+		var st sessionTimeout
+		if err := event.Payload().Unmarshal(&st); err != nil {
+			return err
+		}
+		s, ok := b.sessions[st.Key]
+		if !ok || s.id != st.ID {
+			// Already closed.
+			return nil
+		}
+		idle := time.Since(s.last)
+		if idle < b.gap {
+			// Activity in the meantime, wait for the rest.
+			s.timer = b.startTimer(st, b.gap-idle)
+			return nil
+		}
+		return b.closeSession(st.Key)
+	case cells.TopicReset:
+		b.reset()
+	default:
+		key, err := b.key(event)
+		if err != nil {
+			return err
+		}
+		s, ok := b.sessions[key]
+		if !ok {
+			if b.max > 0 && len(b.sessions) >= b.max {
+				if err := b.closeSession(b.longestIdle()); err != nil {
+					return err
+				}
+			}
+			s = b.openSession(key)
+		}
+		s.last = time.Now()
+		s.sink.Push(event)
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *sessionBehavior) Recover(err interface{}) error {
+	b.reset()
+	return nil
+}
+
+// openSession creates a new session for the key.
+func (b *sessionBehavior) openSession(key string) *session {
+	b.counter++
+	s := &session{
+		id:   b.counter,
+		sink: cells.NewEventSink(0),
+	}
+	s.timer = b.startTimer(sessionTimeout{key, s.id}, b.gap)
+	b.sessions[key] = s
+	return s
+}
+
+// startTimer starts the timer checking the session later.
+func (b *sessionBehavior) startTimer(st sessionTimeout, timeout time.Duration) *time.Timer {
+	return time.AfterFunc(timeout, func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicSessionTimeout, st); err != nil {
+			logger.Errorf("session of cell %q cannot be checked: %v", b.cell.ID(), err)
+		}
+	})
+}
+
+// longestIdle returns the key of the session with the
+// oldest activity.
+func (b *sessionBehavior) longestIdle() string {
+	var key string
+	var last time.Time
+	found := false
+	for k, s := range b.sessions {
+		if !found || s.last.Before(last) {
+			key = k
+			last = s.last
+			found = true
+		}
+	}
+	return key
+}
+
+// closeSession processes the events of the session
+// and emits the result.
+func (b *sessionBehavior) closeSession(key string) error {
+	s, ok := b.sessions[key]
+	if !ok {
+		return nil
+	}
+	s.timer.Stop()
+	delete(b.sessions, key)
+	payload, err := b.process(s.sink)
+	if err != nil {
+		return err
+	}
+	return b.cell.EmitNew(TopicSession, payload)
+}
+
+// reset stops all timers and drops the open sessions.
+func (b *sessionBehavior) reset() {
+	for _, s := range b.sessions {
+		s.timer.Stop()
+	}
+	b.sessions = make(map[string]*session)
+}
+
+// EOF
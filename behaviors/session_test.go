@@ -0,0 +1,91 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Session
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSessionBehavior tests the session behavior.
+func TestSessionBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("session-behavior")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	processor := func(accessor cells.EventSinkAccessor) (cells.Payload, error) {
+		first, _ := accessor.PeekFirst()
+		summary := fmt.Sprintf("%v:%d", first.Payload(), accessor.Len())
+		sigc <- summary
+		return cells.NewPayload(summary)
+	}
+
+	env.StartCell("sessionizer", behaviors.NewSessionBehavior(key, 50*time.Millisecond, 0, processor))
+
+	env.EmitNew("sessionizer", "click", "alice")
+	env.EmitNew("sessionizer", "click", "bob")
+	env.EmitNew("sessionizer", "click", "alice")
+	time.Sleep(20 * time.Millisecond)
+	env.EmitNew("sessionizer", "click", "alice")
+
+	// Bob is idle first.
+	assert.Wait(sigc, "bob:1", time.Second)
+	assert.Wait(sigc, "alice:3", time.Second)
+
+	env.EmitNew("sessionizer", "click", "bob")
+
+	assert.Wait(sigc, "bob:1", time.Second)
+}
+
+// TestSessionBehaviorMax tests the limitation of open sessions.
+func TestSessionBehaviorMax(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("session-behavior-max")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	processor := func(accessor cells.EventSinkAccessor) (cells.Payload, error) {
+		first, _ := accessor.PeekFirst()
+		summary := fmt.Sprintf("%v:%d", first.Payload(), accessor.Len())
+		sigc <- summary
+		return cells.NewPayload(summary)
+	}
+
+	env.StartCell("sessionizer", behaviors.NewSessionBehavior(key, 50*time.Millisecond, 1, processor))
+
+	env.EmitNew("sessionizer", "click", "alice")
+	env.EmitNew("sessionizer", "click", "alice")
+	env.EmitNew("sessionizer", "click", "bob")
+
+	// Alice has to make room for bob.
+	assert.Wait(sigc, "alice:2", time.Second)
+	assert.Wait(sigc, "bob:1", time.Second)
+}
+
+// EOF
@@ -0,0 +1,177 @@
+// Tideland Go Cells - Behaviors - Throttle
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicThrottleTick is used internally to start a new interval.
+	TopicThrottleTick = "throttle:tick"
+)
+
+// defaultThrottleDelayed is the default maximum number of
+// delayed events per key.
+const defaultThrottleDelayed = 1000
+
+// ThrottleMode defines how events exceeding the limit are handled.
+type ThrottleMode int
+
+// Modes of the throttle behavior.
+const (
+	// ThrottleDrop drops the exceeding events.
+	ThrottleDrop ThrottleMode = iota + 1
+
+	// ThrottleDelay delays the exceeding events to the
+	// following intervals.
+	ThrottleDelay
+)
+
+//--------------------
+// THROTTLE BEHAVIOR
+//--------------------
+
+// ThrottleKey returns the key of an event. The limit of the throttle
+// behavior is applied per key.
+type ThrottleKey func(event cells.Event) (string, error)
+
+// throttleBehavior limits the number of emitted events per interval.
+type throttleBehavior struct {
+	cell     cells.Cell
+	limit    int
+	interval time.Duration
+	mode     ThrottleMode
+	max      int
+	key      ThrottleKey
+	counts   map[string]int
+	delayed  map[string][]cells.Event
+	loop     loop.Loop
+}
+
+// NewThrottleBehavior creates a behavior emitting at most limit of the
+// received events per interval. If the key function is not nil the limit
+// is applied per key of the events. The mode defines if exceeding events
+// are dropped or delayed to the following intervals. Delayed events are
+// emitted in the order they have been received. At maximum max events
+// are delayed per key, further ones are dropped. The limit is at least 1,
+// an interval not larger than 0 is set to one second, and a max smaller
+// than 1 is set to 1000.
+func NewThrottleBehavior(limit int, interval time.Duration, mode ThrottleMode, max int, key ThrottleKey) cells.Behavior {
+	if limit < 1 {
+		limit = 1
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if max < 1 {
+		max = defaultThrottleDelayed
+	}
+	return &throttleBehavior{
+		limit:    limit,
+		interval: interval,
+		mode:     mode,
+		max:      max,
+		key:      key,
+		counts:   make(map[string]int),
+		delayed:  make(map[string][]cells.Event),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *throttleBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	b.loop = loop.Go(b.tickerLoop)
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *throttleBehavior) Terminate() error {
+	return b.loop.Stop()
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *throttleBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicThrottleTick:
+		// New interval, emit delayed events first.
+		b.counts = make(map[string]int)
+		for key, events := range b.delayed {
+			n := len(events)
+			if n > b.limit {
+				n = b.limit
+			}
+			for _, delayed := range events[:n] {
+				if err := b.cell.Emit(delayed); err != nil {
+					return err
+				}
+			}
+			b.counts[key] = n
+			if n == len(events) {
+				delete(b.delayed, key)
+			} else {
+				b.delayed[key] = events[n:]
+			}
+		}
+	default:
+		key := ""
+		if b.key != nil {
+			k, err := b.key(event)
+			if err != nil {
+				return err
+			}
+			key = k
+		}
+		if b.counts[key] < b.limit && len(b.delayed[key]) == 0 {
+			b.counts[key]++
+			return b.cell.Emit(event)
+		}
+		if b.mode == ThrottleDelay && len(b.delayed[key]) < b.max {
+			b.delayed[key] = append(b.delayed[key], event)
+		}
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *throttleBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// tickerLoop sends tick events to its own process method.
+func (b *throttleBehavior) tickerLoop(l loop.Loop) error {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case <-ticker.C:
+			// Notify myself, act there to avoid
+			// race with the processing.
+			if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicThrottleTick, nil); err != nil {
+				logger.Errorf("throttle of cell %q cannot start a new interval: %v", b.cell.ID(), err)
+			}
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,174 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Throttle
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestThrottleBehaviorDrop tests the throttle behavior dropping events.
+func TestThrottleBehaviorDrop(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("throttle-behavior-drop")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("throttle", behaviors.NewThrottleBehavior(2, time.Second, behaviors.ThrottleDrop, 0, nil))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("throttle", "signaler")
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("throttle", "sensor", i)
+	}
+
+	assert.Wait(sigc, "0", time.Second)
+	assert.Wait(sigc, "1", time.Second)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestThrottleBehaviorDelay tests the throttle behavior delaying events.
+func TestThrottleBehaviorDelay(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("throttle-behavior-delay")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	start := time.Now()
+	env.StartCell("throttle", behaviors.NewThrottleBehavior(2, 50*time.Millisecond, behaviors.ThrottleDelay, 0, nil))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("throttle", "signaler")
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("throttle", "sensor", i)
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.Wait(sigc, fmt.Sprintf("%d", i), time.Second)
+	}
+	// Needs at least two further intervals.
+	assert.True(time.Since(start) >= 100*time.Millisecond)
+}
+
+// TestThrottleBehaviorDelayMax tests the throttle behavior
+// dropping events when too many are delayed.
+func TestThrottleBehaviorDelayMax(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("throttle-behavior-delay-max")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("throttle", behaviors.NewThrottleBehavior(1, 100*time.Millisecond, behaviors.ThrottleDelay, 2, nil))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("throttle", "signaler")
+
+	for i := 0; i < 5; i++ {
+		env.EmitNew("throttle", "sensor", i)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Wait(sigc, fmt.Sprintf("%d", i), time.Second)
+	}
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// TestThrottleBehaviorKeyed tests the throttle behavior per key.
+func TestThrottleBehaviorKeyed(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("throttle-behavior-keyed")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Topic(), nil
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("throttle", behaviors.NewThrottleBehavior(1, time.Second, behaviors.ThrottleDrop, 0, key))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("throttle", "signaler")
+
+	env.EmitNew("throttle", "a", 1)
+	env.EmitNew("throttle", "a", 2)
+	env.EmitNew("throttle", "b", 3)
+	env.EmitNew("throttle", "b", 4)
+
+	assert.Wait(sigc, "1", time.Second)
+	assert.Wait(sigc, "3", time.Second)
+}
+
+// TestThrottleBehaviorInvalid tests the throttle behavior with
+// invalid limit and interval.
+func TestThrottleBehaviorInvalid(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 100)
+	env := cells.NewEnvironment("throttle-behavior-invalid")
+	defer env.Stop()
+
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Payload().String()
+		return nil
+	}
+
+	env.StartCell("throttle", behaviors.NewThrottleBehavior(0, 0, behaviors.ThrottleDrop, 0, nil))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("throttle", "signaler")
+
+	env.EmitNew("throttle", "sensor", 1)
+	env.EmitNew("throttle", "sensor", 2)
+
+	assert.Wait(sigc, "1", time.Second)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Nil(env.StopCell("throttle"))
+}
+
+// EOF
@@ -0,0 +1,219 @@
+// Tideland Go Cells - Behaviors - Watchdog
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicWatchdogAlarm is used for the events signalling that no
+	// matching event arrived in time.
+	TopicWatchdogAlarm = "watchdog:alarm"
+
+	// TopicWatchdogRecovery is used for the events signalling that
+	// matching events arrive again after an alarm.
+	TopicWatchdogRecovery = "watchdog:recovery"
+
+	// TopicWatchdogTimeout is used internally to check a watchdog.
+	TopicWatchdogTimeout = "watchdog:timeout"
+)
+
+//--------------------
+// WATCHDOG BEHAVIOR
+//--------------------
+
+// WatchdogCriterion checks if an event resets the watchdog.
+type WatchdogCriterion func(event cells.Event) (bool, error)
+
+// WatchdogKey returns the key of an event. One watchdog per key
+// is running.
+type WatchdogKey func(event cells.Event) (string, error)
+
+// Watchdog is emitted with the topics "watchdog:alarm" and
+// "watchdog:recovery". Last is the time of the last matching
+// event before the alarm, it is zero if none has been received.
+type Watchdog struct {
+	Key  string
+	Last time.Time
+}
+
+// watchdogTimeout identifies the timer of a watchdog.
+type watchdogTimeout struct {
+	Key        string
+	Generation int
+}
+
+// watchdog is the state of one key.
+type watchdog struct {
+	last       time.Time
+	generation int
+	alarmed    bool
+	timer      *time.Timer
+}
+
+// watchdogBehavior emits alarms when matching events stop arriving.
+type watchdogBehavior struct {
+	cell      cells.Cell
+	matches   WatchdogCriterion
+	key       WatchdogKey
+	duration  time.Duration
+	watchdogs map[string]*watchdog
+}
+
+// NewWatchdogBehavior creates a behavior watching for events the criterion
+// matches. If none arrived for the duration a Watchdog is emitted with the
+// topic "watchdog:alarm", the next matching one leads to the topic
+// "watchdog:recovery". If the key function is nil only one watchdog is
+// running, it starts with the cell. Otherwise one watchdog per key starts
+// with the first event of the key. A "reset" restarts all watchdogs, with
+// a key as payload only the watchdog of this key is dropped. So watchdogs
+// of keys which are not expected anymore can be removed.
+func NewWatchdogBehavior(matches WatchdogCriterion, key WatchdogKey, duration time.Duration) cells.Behavior {
+	return &watchdogBehavior{
+		matches:   matches,
+		key:       key,
+		duration:  duration,
+		watchdogs: make(map[string]*watchdog),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *watchdogBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	b.start()
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *watchdogBehavior) Terminate() error {
+	b.stop()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *watchdogBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicWatchdogTimeout:
+		var timeout watchdogTimeout
+		if err := event.Payload().Unmarshal(&timeout); err != nil {
+			return err
+		}
+		wd, ok := b.watchdogs[timeout.Key]
+		if !ok || wd.generation != timeout.Generation || wd.alarmed {
+			return nil
+		}
+		wd.alarmed = true
+		return b.cell.EmitNew(TopicWatchdogAlarm, Watchdog{
+			Key:  timeout.Key,
+			Last: wd.last,
+		})
+	case cells.TopicReset:
+		if b.key == nil || event.Payload().Len() == 0 {
+			b.stop()
+			b.start()
+			return nil
+		}
+		b.drop(event.Payload().String())
+	default:
+		ok, err := b.matches(event)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		key := ""
+		if b.key != nil {
+			key, err = b.key(event)
+			if err != nil {
+				return err
+			}
+		}
+		wd, ok := b.watchdogs[key]
+		if !ok {
+			wd = &watchdog{}
+			b.watchdogs[key] = wd
+		}
+		last := wd.last
+		wd.last = time.Now()
+		b.restart(key, wd)
+		if wd.alarmed {
+			wd.alarmed = false
+			return b.cell.EmitNew(TopicWatchdogRecovery, Watchdog{
+				Key:  key,
+				Last: last,
+			})
+		}
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *watchdogBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// start starts the single watchdog if no key function is set.
+func (b *watchdogBehavior) start() {
+	if b.key == nil {
+		wd := &watchdog{}
+		b.watchdogs[""] = wd
+		b.restart("", wd)
+	}
+}
+
+// restart restarts the timer of a watchdog.
+func (b *watchdogBehavior) restart(key string, wd *watchdog) {
+	if wd.timer != nil {
+		wd.timer.Stop()
+	}
+	wd.generation++
+	timeout := watchdogTimeout{
+		Key:        key,
+		Generation: wd.generation,
+	}
+	wd.timer = time.AfterFunc(b.duration, func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicWatchdogTimeout, timeout); err != nil {
+			logger.Errorf("watchdog of cell %q cannot be checked: %v", b.cell.ID(), err)
+		}
+	})
+}
+
+// stop stops and drops all watchdogs.
+func (b *watchdogBehavior) stop() {
+	for key := range b.watchdogs {
+		b.drop(key)
+	}
+}
+
+// drop stops and drops the watchdog of the key.
+func (b *watchdogBehavior) drop(key string) {
+	wd, ok := b.watchdogs[key]
+	if !ok {
+		return
+	}
+	if wd.timer != nil {
+		wd.timer.Stop()
+	}
+	delete(b.watchdogs, key)
+}
+
+// EOF
@@ -0,0 +1,110 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Watchdog
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestWatchdogBehavior tests the watchdog behavior without keys.
+func TestWatchdogBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("watchdog-behavior")
+	defer env.Stop()
+
+	matches := func(event cells.Event) (bool, error) {
+		return event.Topic() == "heartbeat", nil
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var wd behaviors.Watchdog
+		if err := event.Payload().Unmarshal(&wd); err != nil {
+			return err
+		}
+		sigc <- fmt.Sprintf("%s %s %v", event.Topic(), wd.Key, !wd.Last.IsZero())
+		return nil
+	}
+
+	env.StartCell("watchdog", behaviors.NewWatchdogBehavior(matches, nil, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("watchdog", "signaler")
+
+	// Silence from the start.
+	assert.Wait(sigc, "watchdog:alarm  false", time.Second)
+
+	env.EmitNew("watchdog", "heartbeat", "a")
+	assert.Wait(sigc, "watchdog:recovery  false", time.Second)
+
+	// Ignored events do not reset the watchdog.
+	env.EmitNew("watchdog", "noise", "a")
+	assert.Wait(sigc, "watchdog:alarm  true", time.Second)
+}
+
+// TestWatchdogBehaviorKeyed tests the watchdog behavior with
+// one watchdog per key.
+func TestWatchdogBehaviorKeyed(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("watchdog-behavior-keyed")
+	defer env.Stop()
+
+	key := func(event cells.Event) (string, error) {
+		return event.Payload().String(), nil
+	}
+	matches := func(event cells.Event) (bool, error) {
+		return event.Topic() == "heartbeat", nil
+	}
+	signaler := func(cell cells.Cell, event cells.Event) error {
+		var wd behaviors.Watchdog
+		if err := event.Payload().Unmarshal(&wd); err != nil {
+			return err
+		}
+		sigc <- fmt.Sprintf("%s %s %v", event.Topic(), wd.Key, !wd.Last.IsZero())
+		return nil
+	}
+
+	env.StartCell("watchdog", behaviors.NewWatchdogBehavior(matches, key, 50*time.Millisecond))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(signaler))
+	env.Subscribe("watchdog", "signaler")
+
+	env.EmitNew("watchdog", "heartbeat", "a")
+	env.EmitNew("watchdog", "heartbeat", "b")
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		env.EmitNew("watchdog", "heartbeat", "b")
+	}
+
+	assert.Wait(sigc, "watchdog:alarm a true", time.Second)
+	assert.Wait(sigc, "watchdog:alarm b true", time.Second)
+
+	env.EmitNew("watchdog", "heartbeat", "b")
+	assert.Wait(sigc, "watchdog:recovery b true", time.Second)
+	assert.Wait(sigc, "watchdog:alarm b true", time.Second)
+
+	// A dropped watchdog starts again without recovery.
+	env.EmitNew("watchdog", cells.TopicReset, "b")
+	env.EmitNew("watchdog", "heartbeat", "b")
+	assert.Wait(sigc, "watchdog:alarm b true", time.Second)
+}
+
+// EOF
@@ -0,0 +1,271 @@
+// Tideland Go Cells - Behaviors - Window
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// TopicWindow signals the processing result of a closed window.
+	TopicWindow = "window"
+
+	// TopicWindowClose is used internally to close a window.
+	TopicWindowClose = "window:close"
+)
+
+// windowKind describes how windows are created.
+type windowKind int
+
+// Kinds of windows.
+const (
+	tumblingWindow windowKind = iota
+	hoppingWindow
+	slidingWindow
+)
+
+//--------------------
+// WINDOW BEHAVIOR
+//--------------------
+
+// WindowTimestamper returns the time of an event used to assign it to
+// windows. This way windows can work based on the event time.
+type WindowTimestamper func(event cells.Event) (time.Time, error)
+
+// EventTimestamp is a WindowTimestamper using the timestamp of the event.
+func EventTimestamp(event cells.Event) (time.Time, error) {
+	return event.Timestamp(), nil
+}
+
+// windowClose identifies a window to close.
+type windowClose struct {
+	Start int64
+	ID    int
+}
+
+// window contains the events of one time window.
+type window struct {
+	id    int
+	sink  cells.EventSink
+	timer *time.Timer
+}
+
+// windowBehavior collects events in time windows.
+type windowBehavior struct {
+	cell      cells.Cell
+	kind      windowKind
+	size      time.Duration
+	hop       time.Duration
+	timestamp WindowTimestamper
+	lateness  time.Duration
+	process   cells.EventSinkProcessor
+	windows   map[int64]*window
+	counter   int
+}
+
+// NewTumblingWindowBehavior creates a behavior collecting events in fixed
+// sized, non-overlapping, and contiguous time windows. Each event belongs
+// to exactly one window. When a window closes its events are passed to
+// the processor and the returned payload is emitted with the topic "window".
+//
+// If the timestamper is nil the processing time is used, otherwise the
+// returned time of each event. Windows are closed by an internal timer
+// after their end plus the allowed lateness. So out-of-order events are
+// still collected during the lateness, later ones are dropped. A received
+// "reset" as topic drops all open windows.
+func NewTumblingWindowBehavior(
+	size time.Duration,
+	timestamper WindowTimestamper,
+	lateness time.Duration,
+	processor cells.EventSinkProcessor) cells.Behavior {
+	return newWindowBehavior(tumblingWindow, size, size, timestamper, lateness, processor)
+}
+
+// NewHoppingWindowBehavior creates a behavior collecting events in fixed
+// sized time windows starting each hop. If the hop is shorter than the
+// size the windows are overlapping and an event may belong to multiple
+// windows. Timestamper, lateness, and processor work like for the
+// tumbling window behavior.
+func NewHoppingWindowBehavior(
+	size, hop time.Duration,
+	timestamper WindowTimestamper,
+	lateness time.Duration,
+	processor cells.EventSinkProcessor) cells.Behavior {
+	if hop <= 0 {
+		hop = size
+	}
+	return newWindowBehavior(hoppingWindow, size, hop, timestamper, lateness, processor)
+}
+
+// NewSlidingWindowBehavior creates a behavior where each event opens a
+// new window of the given size. It contains this event and all following
+// ones until its end. Timestamper, lateness, and processor work like for
+// the tumbling window behavior.
+func NewSlidingWindowBehavior(
+	size time.Duration,
+	timestamper WindowTimestamper,
+	lateness time.Duration,
+	processor cells.EventSinkProcessor) cells.Behavior {
+	return newWindowBehavior(slidingWindow, size, size, timestamper, lateness, processor)
+}
+
+// newWindowBehavior creates the window behavior for all kinds.
+func newWindowBehavior(
+	kind windowKind,
+	size, hop time.Duration,
+	timestamper WindowTimestamper,
+	lateness time.Duration,
+	processor cells.EventSinkProcessor) cells.Behavior {
+	return &windowBehavior{
+		kind:      kind,
+		size:      size,
+		hop:       hop,
+		timestamp: timestamper,
+		lateness:  lateness,
+		process:   processor,
+		windows:   make(map[int64]*window),
+	}
+}
+
+// Init implements the cells.Behavior interface.
+func (b *windowBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	return nil
+}
+
+// Terminate implements the cells.Behavior interface.
+func (b *windowBehavior) Terminate() error {
+	b.reset()
+	return nil
+}
+
+// ProcessEvent implements the cells.Behavior interface.
+func (b *windowBehavior) ProcessEvent(event cells.Event) error {
+	switch event.Topic() {
+	case TopicWindowClose:
+		var wc windowClose
+		if err := event.Payload().Unmarshal(&wc); err != nil {
+			return err
+		}
+		return b.closeWindow(wc)
+	case cells.TopicReset:
+		b.reset()
+	default:
+		now := time.Now()
+		timestamp := now
+		if b.timestamp != nil {
+			t, err := b.timestamp(event)
+			if err != nil {
+				return err
+			}
+			timestamp = t
+		}
+		for _, start := range b.starts(timestamp) {
+			w, ok := b.windows[start.UnixNano()]
+			if !ok {
+				deadline := start.Add(b.size + b.lateness)
+				if !deadline.After(now) {
+					// Too late for this window.
+					continue
+				}
+				w = b.openWindow(start, deadline.Sub(now))
+			}
+			w.sink.Push(event)
+		}
+	}
+	return nil
+}
+
+// Recover implements the cells.Behavior interface.
+func (b *windowBehavior) Recover(err interface{}) error {
+	b.reset()
+	return nil
+}
+
+// starts returns the start times of all windows the
+// passed timestamp belongs to.
+func (b *windowBehavior) starts(timestamp time.Time) []time.Time {
+	switch b.kind {
+	case hoppingWindow:
+		var starts []time.Time
+		for start := timestamp.Truncate(b.hop); timestamp.Sub(start) < b.size; start = start.Add(-b.hop) {
+			starts = append(starts, start)
+		}
+		return starts
+	case slidingWindow:
+		starts := []time.Time{timestamp}
+		for nanos := range b.windows {
+			start := time.Unix(0, nanos)
+			if start.Before(timestamp) && timestamp.Sub(start) < b.size {
+				starts = append(starts, start)
+			}
+		}
+		return starts
+	default:
+		return []time.Time{timestamp.Truncate(b.size)}
+	}
+}
+
+// openWindow creates a new window and starts the timer
+// closing it.
+func (b *windowBehavior) openWindow(start time.Time, timeout time.Duration) *window {
+	b.counter++
+	w := &window{
+		id:   b.counter,
+		sink: cells.NewEventSink(0),
+	}
+	wc := windowClose{
+		Start: start.UnixNano(),
+		ID:    w.id,
+	}
+	w.timer = time.AfterFunc(timeout, func() {
+		if err := b.cell.Environment().EmitNew(b.cell.ID(), TopicWindowClose, wc); err != nil {
+			logger.Errorf("window of cell %q cannot be closed: %v", b.cell.ID(), err)
+		}
+	})
+	b.windows[wc.Start] = w
+	return w
+}
+
+// closeWindow processes the events of the identified window
+// and emits the result.
+func (b *windowBehavior) closeWindow(wc windowClose) error {
+	w, ok := b.windows[wc.Start]
+	if !ok || w.id != wc.ID {
+		// Already dropped.
+		return nil
+	}
+	delete(b.windows, wc.Start)
+	payload, err := b.process(w.sink)
+	if err != nil {
+		return err
+	}
+	return b.cell.EmitNew(TopicWindow, payload)
+}
+
+// reset stops all timers and drops the open windows.
+func (b *windowBehavior) reset() {
+	for _, w := range b.windows {
+		w.timer.Stop()
+	}
+	b.windows = make(map[int64]*window)
+}
+
+// EOF
@@ -0,0 +1,206 @@
+// Tideland Go Cells - Behaviors - Unit Tests - Window
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gocells/behaviors"
+	"github.com/tideland/gocells/cells"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTumblingWindowBehavior tests the tumbling window behavior.
+func TestTumblingWindowBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("tumbling-window-behavior")
+	defer env.Stop()
+
+	env.StartCell("windower", behaviors.NewTumblingWindowBehavior(50*time.Millisecond, nil, 0, windowPayloads))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(windowSignaler(sigc)))
+	env.Subscribe("windower", "signaler")
+
+	for i := 0; i < 10; i++ {
+		env.EmitNew("windower", "event", i)
+	}
+
+	// The events may be split at a window boundary but
+	// each one is in exactly one window.
+	windows := waitWindows(assert, sigc, 10)
+	assert.Equal(strings.Join(windows, ","), "0,1,2,3,4,5,6,7,8,9")
+}
+
+// TestHoppingWindowBehavior tests the hopping window behavior.
+func TestHoppingWindowBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("hopping-window-behavior")
+	defer env.Stop()
+
+	env.StartCell("windower", behaviors.NewHoppingWindowBehavior(100*time.Millisecond, 50*time.Millisecond, nil, 0, windowPayloads))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(windowSignaler(sigc)))
+	env.Subscribe("windower", "signaler")
+
+	for i := 0; i < 10; i++ {
+		env.EmitNew("windower", "event", i)
+	}
+
+	// Each event is part of two windows, each window
+	// contains a contiguous range of them.
+	windows := waitWindows(assert, sigc, 20)
+	counts := map[string]int{}
+	for _, window := range windows {
+		values := strings.Split(window, ",")
+		for i, value := range values {
+			counts[value]++
+			if i > 0 {
+				assert.Equal(value, nextValue(values[i-1]))
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(counts[fmt.Sprint(i)], 2)
+	}
+}
+
+// TestSlidingWindowBehavior tests the sliding window behavior.
+func TestSlidingWindowBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("sliding-window-behavior")
+	defer env.Stop()
+
+	env.StartCell("windower", behaviors.NewSlidingWindowBehavior(time.Second, nil, 0, windowPayloads))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(windowSignaler(sigc)))
+	env.Subscribe("windower", "signaler")
+
+	for i := 0; i < 3; i++ {
+		env.EmitNew("windower", "event", i)
+	}
+
+	// Each event opens a window containing the following ones.
+	// They close at nearly the same time, so their order varies.
+	windows := waitWindows(assert, sigc, 6)
+	sort.Strings(windows)
+	assert.Equal(windows, []string{"0,1,2", "1,2", "2"})
+}
+
+// TestEventTimeWindowBehavior tests the window behavior working with
+// event times and lateness.
+func TestEventTimeWindowBehavior(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sigc := make(chan interface{}, 10)
+	env := cells.NewEnvironment("event-time-window-behavior")
+	defer env.Stop()
+
+	timestamper := func(event cells.Event) (time.Time, error) {
+		var timestamp time.Time
+		err := event.Payload().Unmarshal(&timestamp)
+		return timestamp, err
+	}
+	processor := func(accessor cells.EventSinkAccessor) (cells.Payload, error) {
+		topics := []string{}
+		err := accessor.Do(func(index int, event cells.Event) error {
+			topics = append(topics, event.Topic())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return cells.NewPayload(strings.Join(topics, ","))
+	}
+
+	env.StartCell("windower", behaviors.NewTumblingWindowBehavior(50*time.Millisecond, timestamper, 100*time.Millisecond, processor))
+	env.StartCell("signaler", behaviors.NewSimpleProcessorBehavior(windowSignaler(sigc)))
+	env.Subscribe("windower", "signaler")
+
+	now := time.Now()
+	env.EmitNew("windower", "in-time", now)
+	env.EmitNew("windower", "out-of-order", now.Add(-10*time.Millisecond))
+	env.EmitNew("windower", "too-late", now.Add(-time.Hour))
+	env.EmitNew("windower", "in-time", now)
+
+	// The out-of-order event may be in an earlier window,
+	// the too late one is dropped.
+	windows := waitWindows(assert, sigc, 3)
+	topics := map[string]int{}
+	for _, window := range windows {
+		for _, topic := range strings.Split(window, ",") {
+			topics[topic]++
+		}
+	}
+	assert.Equal(topics, map[string]int{"in-time": 2, "out-of-order": 1})
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// windowPayloads returns the payloads of the window events
+// as comma separated string.
+func windowPayloads(accessor cells.EventSinkAccessor) (cells.Payload, error) {
+	values := []string{}
+	err := accessor.Do(func(index int, event cells.Event) error {
+		values = append(values, event.Payload().String())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cells.NewPayload(strings.Join(values, ","))
+}
+
+// windowSignaler signals the payloads of emitted windows.
+func windowSignaler(sigc chan interface{}) behaviors.SimpleProcessor {
+	return func(cell cells.Cell, event cells.Event) error {
+		sigc <- event.Topic() + " " + event.Payload().String()
+		return nil
+	}
+}
+
+// waitWindows waits for the emitted windows until they contain
+// the passed number of events and returns their contents.
+func waitWindows(assert audit.Assertion, sigc chan interface{}, total int) []string {
+	windows := []string{}
+	for count := 0; count < total; {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			signal := v.(string)
+			if !strings.HasPrefix(signal, behaviors.TopicWindow+" ") {
+				return fmt.Errorf("unexpected signal %q", signal)
+			}
+			window := strings.TrimPrefix(signal, behaviors.TopicWindow+" ")
+			count += len(strings.Split(window, ","))
+			windows = append(windows, window)
+			return nil
+		}, 2*time.Second)
+	}
+	return windows
+}
+
+// nextValue returns the successor of a number as string.
+func nextValue(value string) string {
+	var i int
+	fmt.Sscan(value, &i)
+	return fmt.Sprint(i + 1)
+}
+
+// EOF
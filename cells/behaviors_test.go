@@ -12,6 +12,7 @@ package cells_test
 //--------------------
 
 import (
+	"errors"
 	"time"
 
 	"github.com/tideland/gocells/cells"
@@ -187,4 +188,144 @@ func (b *emitBehavior) Recover(r interface{}) error {
 	return nil
 }
 
+// slowTerminateBehavior needs time to terminate.
+type slowTerminateBehavior struct {
+	duration time.Duration
+}
+
+var _ cells.Behavior = (*slowTerminateBehavior)(nil)
+
+func (b *slowTerminateBehavior) Init(c cells.Cell) error { return nil }
+
+func (b *slowTerminateBehavior) Terminate() error {
+	time.Sleep(b.duration)
+	return nil
+}
+
+func (b *slowTerminateBehavior) ProcessEvent(event cells.Event) error { return nil }
+
+func (b *slowTerminateBehavior) Recover(r interface{}) error { return nil }
+
+// blockingInitBehavior blocks in Init() until its channel
+// is closed. Terminating is signalled if wanted.
+type blockingInitBehavior struct {
+	release    chan struct{}
+	terminated chan interface{}
+}
+
+var _ cells.Behavior = (*blockingInitBehavior)(nil)
+
+func (b *blockingInitBehavior) Init(c cells.Cell) error {
+	<-b.release
+	return nil
+}
+
+func (b *blockingInitBehavior) Terminate() error {
+	if b.terminated != nil {
+		b.terminated <- true
+	}
+	return nil
+}
+
+func (b *blockingInitBehavior) ProcessEvent(event cells.Event) error { return nil }
+
+func (b *blockingInitBehavior) Recover(r interface{}) error { return nil }
+
+// unsubscribingBehavior blocks in Init() until its channel is
+// closed and unsubscribes its cell from the emitter when terminating.
+// The result is signalled.
+type unsubscribingBehavior struct {
+	release    chan struct{}
+	emitterID  string
+	cell       cells.Cell
+	terminated chan interface{}
+}
+
+var _ cells.Behavior = (*unsubscribingBehavior)(nil)
+
+func (b *unsubscribingBehavior) Init(c cells.Cell) error {
+	b.cell = c
+	<-b.release
+	return nil
+}
+
+func (b *unsubscribingBehavior) Terminate() error {
+	b.terminated <- b.cell.Environment().Unsubscribe(b.emitterID, b.cell.ID())
+	return nil
+}
+
+func (b *unsubscribingBehavior) ProcessEvent(event cells.Event) error { return nil }
+
+func (b *unsubscribingBehavior) Recover(r interface{}) error { return nil }
+
+// selfEmittingInitBehavior emits an event to its own cell in
+// Init() and signals the result and the received topics. Without
+// a channel the initialization fails after emitting.
+type selfEmittingInitBehavior struct {
+	sigc chan interface{}
+}
+
+var _ cells.Behavior = (*selfEmittingInitBehavior)(nil)
+
+func (b *selfEmittingInitBehavior) Init(c cells.Cell) error {
+	err := c.Environment().EmitNew(c.ID(), "init", nil)
+	if b.sigc == nil {
+		return errors.New("failed after emitting")
+	}
+	b.sigc <- err
+	return nil
+}
+
+func (b *selfEmittingInitBehavior) Terminate() error { return nil }
+
+func (b *selfEmittingInitBehavior) ProcessEvent(event cells.Event) error {
+	b.sigc <- event.Topic()
+	return nil
+}
+
+func (b *selfEmittingInitBehavior) Recover(r interface{}) error { return nil }
+
+// batchBehavior processes the events in batches and signals
+// their topics. Panic and ouch topics let the batch fail.
+type batchBehavior struct {
+	sigc    chan interface{}
+	latency time.Duration
+}
+
+var _ cells.BatchBehavior = (*batchBehavior)(nil)
+
+func (b *batchBehavior) Init(c cells.Cell) error { return nil }
+
+func (b *batchBehavior) Terminate() error {
+	b.sigc <- "terminated"
+	return nil
+}
+
+func (b *batchBehavior) ProcessEvent(event cells.Event) error {
+	return errors.New("batch behavior processes single event")
+}
+
+func (b *batchBehavior) ProcessEvents(events []cells.Event) error {
+	topics := []string{}
+	for i, event := range events {
+		switch event.Topic() {
+		case panicTopic:
+			b.sigc <- topics
+			panic(cells.NewBatchError(i, errors.New("panic")))
+		case ouchTopic:
+			b.sigc <- topics
+			return cells.NewBatchError(i, errors.New("ouch"))
+		}
+		topics = append(topics, event.Topic())
+	}
+	b.sigc <- topics
+	return nil
+}
+
+func (b *batchBehavior) Recover(r interface{}) error { return nil }
+
+func (b *batchBehavior) Batching() (int, time.Duration) {
+	return 5, b.latency
+}
+
 // EOF
@@ -12,7 +12,9 @@ package cells
 //--------------------
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tideland/golib/errors"
@@ -26,75 +28,213 @@ import (
 // CONNECTIONS
 //--------------------
 
+// connection is the connection to one cell. The topic
+// patterns and the filter select the emitted events.
+type connection struct {
+	cell     *cell
+	patterns topicPatterns
+	filter   SubscriptionFilter
+}
+
+// accepts checks if the event passes patterns and filter.
+func (csc *connection) accepts(event Event) bool {
+	if !csc.patterns.match(event.Topic()) {
+		return false
+	}
+	if csc.filter == nil {
+		return true
+	}
+	ok, err := csc.filter(event)
+	if err != nil {
+		logger.Errorf("subscription filter of cell %q failed: %v", csc.cell.id, err)
+		return false
+	}
+	return ok
+}
+
 // connections manages the connections to connected
-// cells.
+// cells. Changes copy the list, so reading needs no lock.
 type connections struct {
-	mutex sync.RWMutex
-	cells []*cell
+	mutex       sync.Mutex
+	connections atomic.Value
 }
 
 // newConnections creates an instance of the
 // connection manager.
 func newConnections() *connections {
-	return &connections{
-		cells: []*cell{},
-	}
+	cs := &connections{}
+	cs.connections.Store([]*connection{})
+	return cs
+}
+
+// load returns the current list of connections.
+func (cs *connections) load() []*connection {
+	return cs.connections.Load().([]*connection)
 }
 
 // add adds a new cell with a given identifier to the
-// connections.
-func (cs *connections) add(c *cell) {
+// connections. If it already exists its patterns and
+// filter are replaced.
+func (cs *connections) add(c *cell, patterns topicPatterns, filter SubscriptionFilter) {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
-	for _, csc := range cs.cells {
-		if csc == c {
-			return
+	current := cs.load()
+	changed := make([]*connection, 0, len(current)+1)
+	added := &connection{
+		cell:     c,
+		patterns: patterns,
+		filter:   filter,
+	}
+	for _, csc := range current {
+		if csc.cell == c {
+			changed = append(changed, added)
+			added = nil
+		} else {
+			changed = append(changed, csc)
 		}
 	}
-	cs.cells = append(cs.cells, c)
+	if added != nil {
+		changed = append(changed, added)
+	}
+	cs.connections.Store(changed)
 }
 
 // remove deletes the identified cell.
 func (cs *connections) remove(id string) {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
-	remaining := []*cell{}
-	for _, csc := range cs.cells {
-		if csc.id != id {
+	remaining := []*connection{}
+	for _, csc := range cs.load() {
+		if csc.cell.id != id {
 			remaining = append(remaining, csc)
 		}
 	}
-	cs.cells = remaining
+	cs.connections.Store(remaining)
 }
 
 // ids returns the identifiers of the connected cells.
 func (cs *connections) ids() []string {
 	var ids []string
-	for _, csc := range cs.cells {
-		ids = append(ids, csc.id)
+	for _, csc := range cs.load() {
+		ids = append(ids, csc.cell.id)
 	}
 	return ids
 }
 
+// patterns returns the topic patterns of the connected cells
+// by their identifiers. Nil patterns match all topics.
+func (cs *connections) patterns() map[string][]string {
+	patterns := make(map[string][]string)
+	for _, csc := range cs.load() {
+		patterns[csc.cell.id] = csc.patterns.strings()
+	}
+	return patterns
+}
+
 // do executes the passed function for all connected cells
 // and collects potential errors.
 func (cs *connections) do(f func(c *cell) error) error {
-	cs.mutex.RLock()
-	defer cs.mutex.RUnlock()
+	return cs.doAccepting(nil, f)
+}
+
+// doAccepting executes the passed function for all connected
+// cells accepting the event and collects potential errors. A
+// nil event is accepted by all cells.
+func (cs *connections) doAccepting(event Event, f func(c *cell) error) error {
+	return cs.each(func(csc *connection) error {
+		if event != nil && !csc.accepts(event) {
+			return nil
+		}
+		return f(csc.cell)
+	})
+}
+
+// each executes the passed function for all connections
+// and collects potential errors.
+func (cs *connections) each(f func(csc *connection) error) error {
 	var errs []error
-	for _, csc := range cs.cells {
+	for _, csc := range cs.load() {
 		if err := f(csc); err != nil {
 			errs = append(errs, err)
 		}
 	}
-	switch len(errs) {
-	case 0:
+	return collectErrors(errs)
+}
+
+//--------------------
+// SUBSCRIBER
+//--------------------
+
+// subscriber is a connected cell as passed by SubscribersDo(). It
+// only processes the events accepted by the connection and counts
+// the hops like emitting.
+type subscriber struct {
+	emitter    *cell
+	connection *connection
+}
+
+// ID implements the Subscriber interface.
+func (s *subscriber) ID() string {
+	return s.connection.cell.id
+}
+
+// ProcessEvent implements the Subscriber interface.
+func (s *subscriber) ProcessEvent(event Event) error {
+	event = s.emitter.hop(event)
+	if !s.connection.accepts(event) {
 		return nil
-	case 1:
-		return errs[0]
-	default:
-		return errors.Collect(errs...)
 	}
+	return s.connection.cell.deliver(event)
+}
+
+// ProcessNewEvent implements the Subscriber interface.
+func (s *subscriber) ProcessNewEvent(topic string, payload Payload) error {
+	event, err := NewEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+	return s.ProcessEvent(event)
+}
+
+//--------------------
+// CELL ENVIRONMENT
+//--------------------
+
+// cellEnvironment is the environment as seen by the behavior
+// of a cell.
+type cellEnvironment struct {
+	*environment
+	cell *cell
+}
+
+// Emit implements the Environment interface. Events the behavior
+// emits to its own cell, e.g. by timers, are not limited by the
+// capacity of the queue.
+func (ce *cellEnvironment) Emit(id string, event Event) error {
+	if id != ce.cell.id {
+		return ce.environment.Emit(id, event)
+	}
+	if ce.isDraining() {
+		return errors.New(ErrStopping, errorMessages, "environment "+ce.id)
+	}
+	// Check if the cell is still registered.
+	c, err := ce.cells.receiver(id)
+	if err != nil {
+		return err
+	}
+	if c != ce.cell {
+		return errors.New(ErrInvalidID, errorMessages, id)
+	}
+	return c.processOwnEvent(event)
+}
+
+// EmitNew implements the Environment interface.
+func (ce *cellEnvironment) EmitNew(id, topic string, payload interface{}) error {
+	event, err := NewEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+	return ce.Emit(id, event)
 }
 
 //--------------------
@@ -105,6 +245,8 @@ func (cs *connections) do(f func(c *cell) error) error {
 type cell struct {
 	env                *environment
 	id                 string
+	ctx                context.Context
+	cancel             context.CancelFunc
 	measuringID        string
 	queue              Queue
 	behavior           Behavior
@@ -112,11 +254,19 @@ type cell struct {
 	subscribers        *connections
 	recoveringNumber   int
 	recoveringDuration time.Duration
+	hops               int64
+	pending            int64
+	mutex              sync.Mutex
+	parent             *cell
+	children           []string
+	batch              []Event
 	loop               loop.Loop
 }
 
-// newCell create a new cell around a behavior.
-func newCell(env *environment, id string, behavior Behavior) (*cell, error) {
+// newCell create a new cell around a behavior. The behavior is
+// initialized with init(), events emitted to the cell before are
+// queued.
+func newCell(env *environment, id string, behavior Behavior) *cell {
 	logger.Infof("cell '%s' starts", id)
 	// Init cell runtime.
 	c := &cell{
@@ -128,6 +278,7 @@ func newCell(env *environment, id string, behavior Behavior) (*cell, error) {
 		emitters:    newConnections(),
 		subscribers: newConnections(),
 	}
+	c.ctx, c.cancel = context.WithCancel(env.ctx)
 	// Set configuration.
 	if brf, ok := behavior.(BehaviorRecoveringFrequency); ok {
 		number, duration := brf.RecoveringFrequency()
@@ -141,18 +292,33 @@ func newCell(env *environment, id string, behavior Behavior) (*cell, error) {
 		c.recoveringNumber = minRecoveringNumber
 		c.recoveringDuration = minRecoveringDuration
 	}
-	// Init behavior.
-	if err := behavior.Init(c); err != nil {
-		return nil, errors.Annotate(err, ErrCellInit, errorMessages, id)
+	return c
+}
+
+// init initializes the behavior and starts the backend. If
+// the initialization fails the cell is discarded.
+func (c *cell) init() error {
+	if err := c.behavior.Init(c); err != nil {
+		c.discard()
+		return errors.Annotate(err, ErrCellInit, errorMessages, c.id)
 	}
-	// Start backend.
-	c.loop = loop.GoRecoverable(c.backendLoop, c.checkRecovering, id)
-	return c, nil
+	c.loop = loop.GoRecoverable(c.backendLoop, c.checkRecovering, c.id)
+	return nil
+}
+
+// discard drops a cell whose behavior is not initialized
+// together with its queued events.
+func (c *cell) discard() {
+	c.cancel()
+	c.queue.Close()
 }
 
 // Environment implements the Cell interface.
 func (c *cell) Environment() Environment {
-	return c.env
+	return &cellEnvironment{
+		environment: c.env,
+		cell:        c,
+	}
 }
 
 // ID implements the Cell interface.
@@ -160,13 +326,34 @@ func (c *cell) ID() string {
 	return c.id
 }
 
-// Emit implements the Cell interface.
+// Context implements the Cell interface.
+func (c *cell) Context() context.Context {
+	return c.ctx
+}
+
+// Emit implements the Cell interface. If the maximum hops are
+// limited the hops of the emitted event are those of the currently
+// processed one or its own ones, whichever are more, plus one.
 func (c *cell) Emit(event Event) error {
-	return c.SubscribersDo(func(cs Subscriber) error {
-		return cs.ProcessEvent(event)
+	event = c.hop(event)
+	return c.subscribers.doAccepting(event, func(sc *cell) error {
+		return sc.deliver(event)
 	})
 }
 
+// hop returns the event with the hops of emitting it by the cell
+// if the maximum hops are limited.
+func (c *cell) hop(event Event) Event {
+	if c.env.maxHops() == 0 {
+		return event
+	}
+	hops := event.Hops()
+	if current := int(atomic.LoadInt64(&c.hops)); current > hops {
+		hops = current
+	}
+	return withHops(event, hops+1)
+}
+
 // EmitNew implements the Cell interface.
 func (c *cell) EmitNew(topic string, payload interface{}) error {
 	event, err := NewEvent(topic, payload)
@@ -178,7 +365,40 @@ func (c *cell) EmitNew(topic string, payload interface{}) error {
 
 // ProcessEvent implements the Subscriber interface.
 func (c *cell) ProcessEvent(event Event) error {
-	return c.queue.Emit(event)
+	atomic.AddInt64(&c.pending, 1)
+	if err := c.queue.Emit(event); err != nil {
+		atomic.AddInt64(&c.pending, -1)
+		return err
+	}
+	return nil
+}
+
+// deliver queues an event emitted by another cell. If the queue is
+// full the event is handled as dead letter, so that the emitter does
+// not fail.
+func (c *cell) deliver(event Event) error {
+	err := c.ProcessEvent(event)
+	if errors.IsError(err, ErrQueueFull) {
+		logger.Warningf("cell %q dropped event %q due to full queue", c.id, event.Topic())
+		c.env.deadLetter(c.id, DeadLetterQueueFull, event)
+		return nil
+	}
+	return err
+}
+
+// processOwnEvent queues an event the cell emits to itself. Those
+// are accepted even if the capacity of the queue is reached.
+func (c *cell) processOwnEvent(event Event) error {
+	oq, ok := c.queue.(ownEventsQueue)
+	if !ok {
+		return c.ProcessEvent(event)
+	}
+	atomic.AddInt64(&c.pending, 1)
+	if err := oq.emitOwn(event); err != nil {
+		atomic.AddInt64(&c.pending, -1)
+		return err
+	}
+	return nil
 }
 
 // ProcessNewEvent implements the Subscriber interface.
@@ -190,13 +410,75 @@ func (c *cell) ProcessNewEvent(topic string, payload Payload) error {
 	return c.ProcessEvent(event)
 }
 
-// SubscribersDo implements the Subscriber interface.
+// SubscribersDo implements the Cell interface.
 func (c *cell) SubscribersDo(f func(s Subscriber) error) error {
-	return c.subscribers.do(func(sc *cell) error { return f(Subscriber(sc)) })
+	return c.subscribers.each(func(csc *connection) error {
+		return f(&subscriber{c, csc})
+	})
+}
+
+// drain waits until the queue of the cell is empty or the
+// deadline is reached. It returns the number of pending events.
+func (c *cell) drain(deadline time.Time) int {
+	for {
+		pending := int(atomic.LoadInt64(&c.pending))
+		if pending <= 0 || !time.Now().Before(deadline) {
+			return pending
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Spawn implements the Cell interface.
+func (c *cell) Spawn(behavior Behavior) (string, error) {
+	id := c.id + GroupSeparator + identifier.NewUUID().ShortString()
+	if err := c.env.cells.startChild(c, id, behavior); err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
-// stop terminates the cell.
+// Children implements the Cell interface.
+func (c *cell) Children() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	children := make([]string, len(c.children))
+	copy(children, c.children)
+	return children
+}
+
+// addChild adds a started child.
+func (c *cell) addChild(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.children = append(c.children, id)
+}
+
+// removeChild removes a stopped child.
+func (c *cell) removeChild(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, childID := range c.children {
+		if childID == id {
+			c.children = append(c.children[:i], c.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// stop terminates the cell and notifies the lifecycle observers.
 func (c *cell) stop() error {
+	err := c.terminate()
+	c.env.lifecycle.notify(CellStopped, c.id, "", err)
+	return err
+}
+
+// terminate terminates the cell without notifying the lifecycle
+// observers, e.g. if it never has been registered as started.
+func (c *cell) terminate() error {
+	if c.parent != nil {
+		c.parent.removeChild(c.id)
+	}
 	// Terminate connactions to emitters and subscribers.
 	c.emitters.do(func(ec *cell) error {
 		ec.subscribers.remove(c.id)
@@ -206,9 +488,11 @@ func (c *cell) stop() error {
 		sc.emitters.remove(c.id)
 		return nil
 	})
-	// Stop own backend.
-	c.queue.Close()
+	// Stop own backend before the queue, so that the
+	// behavior terminates regularly.
+	c.cancel()
 	err := c.loop.Stop()
+	c.queue.Close()
 	if err != nil {
 		logger.Errorf("cell '%s' stopped with error: %v", c.id, err)
 	} else {
@@ -223,6 +507,9 @@ func (c *cell) backendLoop(l loop.Loop) error {
 	monitoring.IncrVariable(totalCellsID)
 	defer monitoring.DecrVariable(totalCellsID)
 
+	if bb, ok := c.behavior.(BatchBehavior); ok {
+		return c.batchLoop(l, bb)
+	}
 	for {
 		select {
 		case <-l.ShallStop():
@@ -231,10 +518,7 @@ func (c *cell) backendLoop(l loop.Loop) error {
 			if event == nil {
 				panic("received illegal nil event!")
 			}
-			measuring := monitoring.BeginMeasuring(c.measuringID)
-			err := c.behavior.ProcessEvent(event)
-			measuring.EndMeasuring()
-			if err != nil {
+			if err := c.processEvent(event); err != nil {
 				logger.Errorf("cell %q processed event %q with error: %v", c.id, event.Topic(), err)
 				return err
 			}
@@ -242,25 +526,178 @@ func (c *cell) backendLoop(l loop.Loop) error {
 	}
 }
 
+// batchLoop collects the events of the queue into batches for
+// the batch behavior.
+func (c *cell) batchLoop(l loop.Loop, bb BatchBehavior) error {
+	size, latency := defaultBatchSize, defaultBatchLatency
+	if bb, ok := c.behavior.(BehaviorBatching); ok {
+		size, latency = bb.Batching()
+		if size < 1 {
+			size = 1
+		}
+		if latency <= 0 {
+			latency = defaultBatchLatency
+		}
+	}
+	// Remaining events of a batch after recovering.
+	if err := c.processBatch(bb); err != nil {
+		return err
+	}
+	var latencyc <-chan time.Time
+	for {
+		select {
+		case <-l.ShallStop():
+			c.processLastBatch(bb)
+			return c.behavior.Terminate()
+		case event := <-c.queue.Events():
+			if event == nil {
+				panic("received illegal nil event!")
+			}
+			c.batch = append(c.batch, event)
+			if len(c.batch) == 1 {
+				latencyc = time.After(latency)
+			}
+			if len(c.batch) < size {
+				continue
+			}
+		case <-latencyc:
+		}
+		latencyc = nil
+		if err := c.processBatch(bb); err != nil {
+			logger.Errorf("cell %q processed batch with error: %v", c.id, err)
+			return err
+		}
+	}
+}
+
+// processBatch lets the batch behavior process the collected
+// events which are not expired or have too many hops.
+func (c *cell) processBatch(bb BatchBehavior) error {
+	maxHops := c.env.maxHops()
+	hops := 0
+	valid := make([]Event, 0, len(c.batch))
+	for _, event := range c.batch {
+		if deadline, ok := event.Deadline(); ok && time.Now().After(deadline) {
+			c.env.deadLetter(c.id, DeadLetterExpired, event)
+			atomic.AddInt64(&c.pending, -1)
+			continue
+		}
+		if maxHops > 0 && event.Hops() > maxHops {
+			logger.Warningf("cell %q dropped event %q after %d hops", c.id, event.Topic(), event.Hops())
+			c.env.deadLetter(c.id, DeadLetterMaxHops, event)
+			atomic.AddInt64(&c.pending, -1)
+			continue
+		}
+		if event.Hops() > hops {
+			hops = event.Hops()
+		}
+		valid = append(valid, event)
+	}
+	c.batch = valid
+	if len(c.batch) == 0 {
+		return nil
+	}
+	atomic.StoreInt64(&c.hops, int64(hops))
+	measuring := monitoring.BeginMeasuring(c.measuringID)
+	err := bb.ProcessEvents(c.batch)
+	measuring.EndMeasuring()
+	atomic.StoreInt64(&c.hops, 0)
+	if err != nil {
+		// The batch is handled when recovering.
+		return err
+	}
+	atomic.AddInt64(&c.pending, -int64(len(c.batch)))
+	c.batch = nil
+	return nil
+}
+
+// processLastBatch processes the batch when stopping. A failure
+// cannot be recovered anymore, so the failed and the following
+// events are dead letters.
+func (c *cell) processLastBatch(bb BatchBehavior) {
+	defer func() {
+		if reason := recover(); reason != nil {
+			logger.Errorf("cell %q processed last batch with panic: %v", c.id, reason)
+			c.failBatch(reason, false)
+		}
+	}()
+	if err := c.processBatch(bb); err != nil {
+		logger.Errorf("cell %q processed last batch with error: %v", c.id, err)
+		c.failBatch(err, false)
+	}
+}
+
+// failBatch handles the failed processing of the current batch after
+// an error or a panic. The events before the failed one are processed,
+// the failed one becomes a dead letter. The following ones are kept for
+// processing if wanted, otherwise they are dead letters too. If the
+// failed event is unknown the whole batch failed.
+func (c *cell) failBatch(reason interface{}, keep bool) {
+	index, ok := batchErrorIndex(reason)
+	if !ok || index < 0 || index >= len(c.batch) {
+		index = 0
+		keep = false
+	}
+	atomic.AddInt64(&c.pending, -int64(index))
+	end := len(c.batch)
+	if keep {
+		end = index + 1
+	}
+	for _, event := range c.batch[index:end] {
+		c.env.deadLetter(c.id, DeadLetterBatchFailed, event)
+		atomic.AddInt64(&c.pending, -1)
+	}
+	c.batch = c.batch[end:]
+}
+
+// processEvent lets the behavior process one event of the queue
+// if it is not expired or has too many hops.
+func (c *cell) processEvent(event Event) error {
+	defer atomic.AddInt64(&c.pending, -1)
+	if deadline, ok := event.Deadline(); ok && time.Now().After(deadline) {
+		c.env.deadLetter(c.id, DeadLetterExpired, event)
+		return nil
+	}
+	if maxHops := c.env.maxHops(); maxHops > 0 && event.Hops() > maxHops {
+		logger.Warningf("cell %q dropped event %q after %d hops", c.id, event.Topic(), event.Hops())
+		c.env.deadLetter(c.id, DeadLetterMaxHops, event)
+		return nil
+	}
+	atomic.StoreInt64(&c.hops, int64(event.Hops()))
+	defer atomic.StoreInt64(&c.hops, 0)
+	measuring := monitoring.BeginMeasuring(c.measuringID)
+	defer measuring.EndMeasuring()
+	return c.behavior.ProcessEvent(event)
+}
+
 // checkRecovering checks if the cell may recover after a panic. It will
 // signal an error and let the cell stop working if there have been 12 recoverings
 // during the last minute or the behaviors Recover() signals, that it cannot
 // handle the error.
 func (c *cell) checkRecovering(rs loop.Recoverings) (loop.Recoverings, error) {
 	logger.Warningf("recovering cell %q after error: %v", c.id, rs.Last().Reason)
+	c.env.lifecycle.notify(CellRecovering, c.id, "", rs.Last().Reason)
+	if len(c.batch) > 0 {
+		c.failBatch(rs.Last().Reason, true)
+	}
 	// Check frequency.
 	if rs.Frequency(c.recoveringNumber, c.recoveringDuration) {
+		c.failBatch(nil, false)
 		err := errors.New(ErrRecoveredTooOften, errorMessages, rs.Last().Reason)
 		logger.Errorf("recovering frequency of cell %q too high", c.id)
+		c.env.lifecycle.notify(CellGivenUp, c.id, "", err)
 		return nil, err
 	}
 	// Try to recover.
 	if err := c.behavior.Recover(rs.Last().Reason); err != nil {
+		c.failBatch(nil, false)
 		err := errors.Annotate(err, ErrEventRecovering, errorMessages, rs.Last().Reason)
 		logger.Errorf("recovering of cell %q failed: %v", c.id, err)
+		c.env.lifecycle.notify(CellGivenUp, c.id, "", err)
 		return nil, err
 	}
 	logger.Infof("successfully recovered cell %q", c.id)
+	c.env.lifecycle.notify(CellRecovered, c.id, "", nil)
 	return rs.Trim(c.recoveringNumber), nil
 }
 
@@ -12,6 +12,7 @@ package cells
 //--------------------
 
 import (
+	"context"
 	"time"
 )
 
@@ -25,6 +26,9 @@ type Environment interface {
 	// the ID can by set manually or is generated automatically.
 	ID() string
 
+	// Configure applies the passed options to the environment.
+	Configure(options ...Option) error
+
 	// StartCell starts a new cell with a given ID and its behavior.
 	StartCell(id string, behavior Behavior) error
 
@@ -34,25 +38,150 @@ type Environment interface {
 	// HasCell returns true if the cell with the given ID exists.
 	HasCell(id string) bool
 
+	// Group returns the group of cells whose IDs start with the
+	// name and the GroupSeparator. Nested groups are separated
+	// the same way, e.g. "orders/eu".
+	Group(name string) Group
+
 	// Subscribe assigns cells as receivers of the emitted
 	// events of the first cell.
 	Subscribe(emitterID string, subscriberIDs ...string) error
 
+	// SubscribeTopics assigns a cell as receiver of those emitted
+	// events of the first cell whose topics match one of the patterns.
+	// Topics are separated by dots into segments. A "*" in a pattern
+	// matches one segment, a "**" any number of segments, e.g.
+	// "sensor.*.temperature" or "sensor.**". Other segments may contain
+	// wildcards like path.Match() supports. Subscribing again replaces
+	// the patterns, no pattern means all topics.
+	SubscribeTopics(emitterID, subscriberID string, patterns ...string) error
+
+	// SubscribeFiltered works like SubscribeTopics but additionally
+	// only those events are received the filter accepts. It is called
+	// during the emitting, so it has to be fast and must not block.
+	SubscribeFiltered(emitterID, subscriberID string, filter SubscriptionFilter, patterns ...string) error
+
 	// Subscribers returns the subscribers of the passed ID.
 	Subscribers(id string) ([]string, error)
 
+	// Subscriptions returns the topic patterns of the subscribers of
+	// the passed ID. Subscribers receiving all events have nil patterns.
+	Subscriptions(id string) (map[string][]string, error)
+
 	// Unsubscribe removes the assignment of emitting und subscribed cells.
 	Unsubscribe(emitterID string, unsubscriberIDs ...string) error
 
-	// Emit emits an event to the cell with a given ID.
+	// Emit emits an event to the cell with a given ID. Cells still
+	// initializing queue the events, e.g. those their behaviors emit
+	// to themselves in Init(). They are processed afterwards.
 	Emit(id string, event Event) error
 
 	// EmitNew creates an event and emits it to the cell
 	// with a given ID.
 	EmitNew(id, topic string, payload interface{}) error
 
+	// EmitAt emits an event to the cell with a given ID at the
+	// given time. The returned handle allows to cancel it.
+	EmitAt(id string, at time.Time, event Event) (Scheduled, error)
+
+	// EmitAfter emits an event to the cell with a given ID after
+	// the given duration. The returned handle allows to cancel it.
+	EmitAfter(id string, after time.Duration, event Event) (Scheduled, error)
+
+	// ObserveLifecycle registers an observer informed about the
+	// lifecycle events of the cells. They are passed asynchronously
+	// but in order. The returned function removes the observer.
+	ObserveLifecycle(observer LifecycleObserver) func()
+
+	// SnapshotScheduled returns the pending scheduled events as JSON.
+	SnapshotScheduled() ([]byte, error)
+
+	// RestoreScheduled schedules the events of a snapshot again. Those
+	// which are overdue are emitted immediately. All addressed cells
+	// have to exist and the environment must not be stopped.
+	RestoreScheduled(snapshot []byte) error
+
 	// Stop manages the proper finalization of an environment.
 	Stop() error
+
+	// Drain stops the environment after processing the pending events.
+	// Lost events due to the timeout are reported with ErrEventsLost.
+	Drain(timeout time.Duration) error
+
+	// StopWithContext works like Stop but returns an error if the
+	// context is done before all cells are stopped. In this case the
+	// stopping continues in the background.
+	StopWithContext(ctx context.Context) error
+}
+
+//--------------------
+// GROUP
+//--------------------
+
+// Group allows to work with cells grouped by the prefixes
+// of their IDs.
+type Group interface {
+	// Name returns the name of the group.
+	Name() string
+
+	// ID returns the ID of a cell in the group, e.g. "orders/validator"
+	// for the cell "validator" in the group "orders".
+	ID(id string) string
+
+	// Group returns a nested group.
+	Group(name string) Group
+
+	// StartCell starts a new cell in the group.
+	StartCell(id string, behavior Behavior) error
+
+	// StartCells starts new cells in the group using the factory
+	// as template for their behaviors.
+	StartCells(factory BehaviorFactory, ids ...string) error
+
+	// Cells returns the sorted IDs of all cells of the group
+	// including those of nested groups.
+	Cells() []string
+
+	// FanIn subscribes the cell to all other cells of the group.
+	FanIn(subscriberID string) error
+
+	// FanOut subscribes all other cells of the group to the cell.
+	FanOut(emitterID string) error
+
+	// Stop stops all cells of the group.
+	Stop() error
+}
+
+// BehaviorFactory creates a behavior for the cell with the
+// passed ID.
+type BehaviorFactory func(id string) Behavior
+
+//--------------------
+// SUBSCRIPTION FILTER
+//--------------------
+
+// SubscriptionFilter decides if an emitted event is received by a
+// subscriber. Errors are logged and the event is not received.
+type SubscriptionFilter func(event Event) (bool, error)
+
+//--------------------
+// SCHEDULED
+//--------------------
+
+// Scheduled is the handle of an event emitted by EmitAt or EmitAfter.
+type Scheduled interface {
+	// ID returns the ID of the cell receiving the event.
+	ID() string
+
+	// At returns the time the event will be emitted.
+	At() time.Time
+
+	// Event returns the scheduled event.
+	Event() Event
+
+	// Cancel cancels the scheduled event. It returns false if
+	// it already has been emitted or cancelled.
+	Cancel() bool
 }
 
 //--------------------
@@ -100,6 +229,11 @@ type Cell interface {
 	// can be started multiple times but has to use different IDs.
 	ID() string
 
+	// Context returns the context of the cell. It is done when the cell
+	// or its environment are stopped, so long-running processing can
+	// observe it.
+	Context() context.Context
+
 	// Emit emits an event to all subscribers of a cell.
 	Emit(event Event) error
 
@@ -107,7 +241,18 @@ type Cell interface {
 	EmitNew(topic string, payload interface{}) error
 
 	// SubscribersDo calls the passed function for each subscriber.
+	// They only process the events matching their topic patterns
+	// and accepted by their filters.
 	SubscribersDo(f func(s Subscriber) error) error
+
+	// Spawn starts a child cell with the passed behavior and returns
+	// its generated ID. It is in the group of the cell and the cell is
+	// subscribed to it, so events can be sent to the child and its
+	// results are received. Children are stopped with their parent.
+	Spawn(behavior Behavior) (string, error)
+
+	// Children returns the IDs of the running child cells.
+	Children() []string
 }
 
 //--------------------
@@ -145,4 +290,25 @@ type BehaviorRecoveringFrequency interface {
 	RecoveringFrequency() (int, time.Duration)
 }
 
+// BatchBehavior is an additional optional interface for a behavior to process
+// multiple events at once, e.g. to write them together. The cell collects the
+// events until the batch is full or the latency of its first event is reached
+// and then calls ProcessEvents() instead of ProcessEvent(). If the processing
+// fails at an event the behavior can return or panic with NewBatchError(). The
+// events before the failed one are seen as processed, the failed one is
+// handled as dead letter. The following events are processed again when the
+// cell recovered. Without NewBatchError() the whole batch is handled as failed.
+// When the cell stops the last batch is processed too. If it fails, all events
+// from the failed one on are dead letters and the behavior terminates anyway.
+type BatchBehavior interface {
+	ProcessEvents(events []Event) error
+}
+
+// BehaviorBatching is an additional optional interface for a batch behavior to
+// set the maximum number of events of a batch and the maximum latency of the
+// first event.
+type BehaviorBatching interface {
+	Batching() (int, time.Duration)
+}
+
 // EOF
@@ -12,6 +12,13 @@ package cells_test
 //--------------------
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -162,6 +169,121 @@ func TestEnvironmentSubscribeUnsubscribe(t *testing.T) {
 	assert.Empty(subs)
 }
 
+// TestEnvironmentSubscribeTopics tests subscribing with
+// topic patterns.
+func TestEnvironmentSubscribeTopics(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("subscribe-topics")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 20)
+	signaler := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- cell.ID() + " " + event.Topic()
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("emitter", newEmitBehavior()))
+	assert.Nil(env.StartCell("all", newSimpleBehavior(signaler)))
+	assert.Nil(env.StartCell("temperatures", newSimpleBehavior(signaler)))
+	assert.Nil(env.StartCell("sensors", newSimpleBehavior(signaler)))
+	assert.Nil(env.StartCell("alarms", newSimpleBehavior(signaler)))
+
+	assert.Nil(env.Subscribe("emitter", "all"))
+	assert.Nil(env.SubscribeTopics("emitter", "temperatures", "sensor.*.temperature"))
+	assert.Nil(env.SubscribeTopics("emitter", "sensors", "sensor.**"))
+	assert.Nil(env.SubscribeTopics("emitter", "alarms", "alarm-[0-9]", "*.alarm"))
+
+	err := env.SubscribeTopics("emitter", "alarms", "alarm-[")
+	assert.True(errors.IsError(err, cells.ErrInvalidPattern))
+	err = env.SubscribeTopics("emitter", "unknown", "*")
+	assert.True(errors.IsError(err, cells.ErrInvalidID))
+
+	subscriptions, err := env.Subscriptions("emitter")
+	assert.Nil(err)
+	assert.Length(subscriptions, 4)
+	assert.Nil(subscriptions["all"])
+	assert.Equal(subscriptions["alarms"], []string{"alarm-[0-9]", "*.alarm"})
+
+	for _, topic := range []string{
+		"sensor.kitchen.temperature",
+		"sensor.kitchen.humidity",
+		"sensor.kitchen.oven.temperature",
+		"alarm-1",
+		"fire.alarm",
+		"sensor",
+	} {
+		env.EmitNew("emitter", topic, nil)
+	}
+
+	received := map[interface{}]bool{}
+	for i := 0; i < 13; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Length(received, 13)
+	assert.True(received["temperatures sensor.kitchen.temperature"])
+	assert.False(received["temperatures sensor.kitchen.oven.temperature"])
+	assert.True(received["sensors sensor.kitchen.oven.temperature"])
+	assert.True(received["sensors sensor"])
+	assert.True(received["alarms alarm-1"])
+	assert.True(received["alarms fire.alarm"])
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestEnvironmentSubscribeFiltered tests subscribing with
+// a filter.
+func TestEnvironmentSubscribeFiltered(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("subscribe-filtered")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 20)
+	signaler := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- cell.ID() + " " + event.Payload().String()
+		return nil, nil
+	}
+	hot := func(event cells.Event) (bool, error) {
+		var temperature int
+		if err := event.Payload().Unmarshal(&temperature); err != nil {
+			return false, err
+		}
+		return temperature > 30, nil
+	}
+	assert.Nil(env.StartCell("emitter", newEmitBehavior()))
+	assert.Nil(env.StartCell("alarm", newSimpleBehavior(signaler)))
+	assert.Nil(env.SubscribeFiltered("emitter", "alarm", hot, "temperature"))
+
+	env.EmitNew("emitter", "temperature", 20)
+	env.EmitNew("emitter", "temperature", "invalid")
+	env.EmitNew("emitter", "humidity", 40)
+	env.EmitNew("emitter", "temperature", 35)
+	assert.Wait(sigc, "alarm 35", time.Second)
+
+	// Unsubscribing removes the filter.
+	assert.Nil(env.Unsubscribe("emitter", "alarm"))
+	assert.Nil(env.Subscribe("emitter", "alarm"))
+	env.EmitNew("emitter", "temperature", 20)
+	assert.Wait(sigc, "alarm 20", time.Second)
+
+	// Filters also apply to SubscribersDo().
+	iterator := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		err := cell.SubscribersDo(func(sub cells.Subscriber) error {
+			return sub.ProcessEvent(event)
+		})
+		return nil, err
+	}
+	assert.Nil(env.StartCell("iterator", newSimpleBehavior(iterator)))
+	assert.Nil(env.SubscribeFiltered("iterator", "alarm", hot))
+	env.EmitNew("iterator", "temperature", 25)
+	env.EmitNew("iterator", "temperature", 40)
+	assert.Wait(sigc, "alarm 40", time.Second)
+}
+
 // TestEnvironmentStopUnsubscribe tests the unsubscribe of a cell when
 // it is stopped.
 func TestEnvironmentStopUnsubscribe(t *testing.T) {
@@ -220,15 +342,807 @@ func TestEnvironmentSubscribersDo(t *testing.T) {
 	env.StartCell("bar", newSimpleBehavior(bar))
 	env.StartCell("iterator", newSimpleBehavior(iterator))
 
-	err := env.Subscribe("iterator", "foo", "bar")
+	err := env.Subscribe("iterator", "foo")
+	assert.Nil(err)
+	err = env.SubscribeTopics("iterator", "bar", "ping")
+	assert.Nil(err)
+	err = env.EmitNew("iterator", "pong", nil)
 	assert.Nil(err)
 	err = env.EmitNew("iterator", "ping", nil)
 	assert.Nil(err)
 
+	// Topic patterns apply here too.
+	assert.Wait(fooc, "foo/pong", 2*time.Second)
 	assert.Wait(fooc, "foo/ping", 2*time.Second)
 	assert.Wait(barc, "bar/ping", 2*time.Second)
 }
 
+// TestEnvironmentEmitScheduled tests the scheduled emitting
+// of events.
+func TestEnvironmentEmitScheduled(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("emit-scheduled")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	signaler := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- event.Topic()
+		return nil, nil
+	}
+	env.StartCell("signaler", newSimpleBehavior(signaler))
+
+	now := time.Now()
+	later, _ := cells.NewEvent("later", nil)
+	cancelled, _ := cells.NewEvent("cancelled", nil)
+	sooner, _ := cells.NewEvent("sooner", nil)
+	at, _ := cells.NewEvent("at", nil)
+
+	_, err := env.EmitAfter("signaler", 100*time.Millisecond, later)
+	assert.Nil(err)
+	s, err := env.EmitAfter("signaler", 50*time.Millisecond, cancelled)
+	assert.Nil(err)
+	_, err = env.EmitAfter("signaler", 20*time.Millisecond, sooner)
+	assert.Nil(err)
+	_, err = env.EmitAt("signaler", now.Add(60*time.Millisecond), at)
+	assert.Nil(err)
+	_, err = env.EmitAfter("unknown", time.Millisecond, at)
+	assert.True(errors.IsError(err, cells.ErrInvalidID))
+
+	assert.Equal(s.ID(), "signaler")
+	assert.Equal(s.Event(), cancelled)
+	assert.True(s.Cancel())
+	assert.False(s.Cancel())
+
+	assert.Wait(sigc, "sooner", time.Second)
+	assert.Wait(sigc, "at", time.Second)
+	assert.Wait(sigc, "later", time.Second)
+	assert.True(time.Since(now) >= 100*time.Millisecond)
+}
+
+// TestEnvironmentSnapshotScheduled tests the snapshot and restoring
+// of scheduled events.
+func TestEnvironmentSnapshotScheduled(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("snapshot-scheduled")
+
+	env.StartCell("null", &nullBehavior{})
+	event, _ := cells.NewEvent("restored", "payload")
+	_, err := env.EmitAfter("null", time.Hour, event)
+	assert.Nil(err)
+	_, err = env.EmitAfter("null", 50*time.Millisecond, event)
+	assert.Nil(err)
+
+	snapshot, err := env.SnapshotScheduled()
+	assert.Nil(err)
+	assert.Nil(env.Stop())
+	assert.True(errors.IsError(env.RestoreScheduled(snapshot), cells.ErrStopping))
+
+	env = cells.NewEnvironment("snapshot-scheduled-restored")
+	defer env.Stop()
+	assert.True(errors.IsError(env.RestoreScheduled(snapshot), cells.ErrInvalidID))
+
+	sigc := make(chan interface{}, 10)
+	signaler := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- event.Topic() + " " + event.Payload().String()
+		return nil, nil
+	}
+	env.StartCell("null", newSimpleBehavior(signaler))
+	assert.Nil(env.RestoreScheduled(snapshot))
+
+	assert.Wait(sigc, "restored payload", time.Second)
+	restored, err := env.SnapshotScheduled()
+	assert.Nil(err)
+	var pending []map[string]interface{}
+	assert.Nil(json.Unmarshal(restored, &pending))
+	assert.Length(pending, 1)
+	assert.Equal(pending[0]["Topic"], "restored")
+}
+
+// TestEnvironmentExpiredEvents tests the skipping of expired events
+// and the emitting of dead letters.
+func TestEnvironmentExpiredEvents(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("expired-events")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	slow := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		time.Sleep(50 * time.Millisecond)
+		sigc <- event.Topic()
+		return nil, nil
+	}
+	deadLetters := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		var dl cells.DeadLetter
+		if err := event.Payload().Unmarshal(&dl); err != nil {
+			return nil, err
+		}
+		sigc <- dl.Reason + " " + dl.CellID + " " + dl.Topic
+		return nil, nil
+	}
+	env.StartCell("slow", newSimpleBehavior(slow))
+	env.StartCell("dead-letters", newSimpleBehavior(deadLetters))
+
+	assert.True(errors.IsError(env.Configure(cells.DeadLetters("unknown")), cells.ErrInvalidID))
+	assert.Nil(env.Configure(cells.DeadLetters("dead-letters")))
+
+	first, _ := cells.NewEvent("first", nil)
+	expiring, _ := cells.NewEventWithTTL("expiring", nil, 10*time.Millisecond)
+	lasting, _ := cells.NewEventWithTTL("lasting", nil, time.Minute)
+	env.Emit("slow", first)
+	env.Emit("slow", expiring)
+	env.Emit("slow", lasting)
+
+	assert.Wait(sigc, "first", time.Second)
+	assert.Wait(sigc, "expired slow expiring", time.Second)
+	assert.Wait(sigc, "lasting", time.Second)
+}
+
+// TestEnvironmentDetectCycles tests the rejection of subscriptions
+// creating cycles.
+func TestEnvironmentDetectCycles(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("detect-cycles")
+	defer env.Stop()
+
+	assert.Nil(env.Configure(cells.DetectCycles(true)))
+	for _, id := range []string{"a", "b", "c", "d"} {
+		assert.Nil(env.StartCell(id, newEmitBehavior()))
+	}
+	assert.Nil(env.Subscribe("a", "b", "d"))
+	assert.Nil(env.Subscribe("b", "c"))
+	assert.Nil(env.Subscribe("d", "c"))
+
+	assert.True(errors.IsError(env.Subscribe("c", "a"), cells.ErrCycle))
+	assert.True(errors.IsError(env.SubscribeTopics("c", "b", "*"), cells.ErrCycle))
+	assert.True(errors.IsError(env.Subscribe("a", "a"), cells.ErrCycle))
+
+	assert.Nil(env.Configure(cells.DetectCycles(false)))
+	assert.Nil(env.Subscribe("c", "a"))
+}
+
+// TestEnvironmentMaxHops tests the dropping of events
+// circulating in cycles.
+func TestEnvironmentMaxHops(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("max-hops")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	deadLetters := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		var dl cells.DeadLetter
+		if err := event.Payload().Unmarshal(&dl); err != nil {
+			return nil, err
+		}
+		sigc <- dl.Reason + " " + dl.CellID + " " + dl.Topic
+		return nil, nil
+	}
+	mapper := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		return cells.NewEvent(event.Topic()+"+", nil)
+	}
+	assert.Nil(env.StartCell("ping", newSimpleBehavior(mapper)))
+	assert.Nil(env.StartCell("pong", newEmitBehavior()))
+	assert.Nil(env.StartCell("dead-letters", newSimpleBehavior(deadLetters)))
+	assert.Nil(env.Subscribe("ping", "pong"))
+	assert.Nil(env.Subscribe("pong", "ping"))
+	assert.Nil(env.Configure(cells.MaxHops(4), cells.DeadLetters("dead-letters")))
+
+	env.EmitNew("ping", "x", nil)
+
+	// Hops: ping 0, pong 1, ping 2, pong 3, ping 4, pong 5.
+	assert.Wait(sigc, "max-hops pong x+++", time.Second)
+}
+
+// TestEnvironmentContext tests stopping the environment
+// by cancelling its context.
+func TestEnvironmentContext(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	env := cells.NewEnvironmentWithContext(ctx, "context")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 2)
+	waiter := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- "waiting"
+		<-cell.Context().Done()
+		sigc <- cell.Context().Err()
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("waiter", newSimpleBehavior(waiter)))
+	env.EmitNew("waiter", "wait", nil)
+	assert.Wait(sigc, "waiting", time.Second)
+
+	cancel()
+	assert.Wait(sigc, context.Canceled, time.Second)
+	for env.HasCell("waiter") {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestEnvironmentStopWithContext tests the bounded stopping
+// of an environment.
+func TestEnvironmentStopWithContext(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("stop-with-context")
+
+	assert.Nil(env.StartCell("slow", &slowTerminateBehavior{time.Second}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := env.StopWithContext(ctx)
+	assert.True(errors.IsError(err, cells.ErrTimeout))
+
+	env = cells.NewEnvironment("stop-with-context-in-time")
+	assert.Nil(env.StartCell("fast", &slowTerminateBehavior{time.Millisecond}))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(env.StopWithContext(ctx))
+}
+
+// TestEnvironmentDrain tests the draining of an environment
+// in topological order.
+func TestEnvironmentDrain(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("drain")
+	defer env.Stop()
+
+	sink := cells.NewEventSink(0)
+	slow := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		time.Sleep(5 * time.Millisecond)
+		return event, nil
+	}
+	assert.Nil(env.StartCell("source", newEmitBehavior()))
+	assert.Nil(env.StartCell("slow", newSimpleBehavior(slow)))
+	assert.Nil(env.StartCell("sink", newCollectBehavior(sink)))
+	assert.Nil(env.Subscribe("source", "slow"))
+	assert.Nil(env.Subscribe("slow", "sink"))
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(env.EmitNew("source", "event", i))
+	}
+	assert.Nil(env.Drain(5 * time.Second))
+	assert.Length(sink, 20)
+
+	err := env.EmitNew("source", "event", 21)
+	assert.True(errors.IsError(err, cells.ErrStopping))
+	assert.False(env.HasCell("sink"))
+}
+
+// TestEnvironmentDrainTimeout tests the reporting of lost events
+// when draining takes too long.
+func TestEnvironmentDrainTimeout(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("drain-timeout")
+	defer env.Stop()
+
+	slow := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("slow", newSimpleBehavior(slow)))
+	for i := 0; i < 10; i++ {
+		assert.Nil(env.EmitNew("slow", "event", i))
+	}
+	err := env.Drain(50 * time.Millisecond)
+	assert.True(errors.IsError(err, cells.ErrEventsLost))
+}
+
+// TestEnvironmentStartWhileStopping tests that cells cannot be
+// started while draining or after stopping and that those still
+// initializing are stopped too.
+func TestEnvironmentStartWhileStopping(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("start-while-stopping")
+	defer env.Stop()
+
+	slow := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		time.Sleep(100 * time.Millisecond)
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("slow", newSimpleBehavior(slow)))
+	assert.Nil(env.EmitNew("slow", "event", 1))
+	release := make(chan struct{})
+	terminatedc := audit.MakeSigChan()
+	go env.StartCell("late", &blockingInitBehavior{release, terminatedc})
+	time.Sleep(10 * time.Millisecond)
+
+	drainc := audit.MakeSigChan()
+	go func() {
+		drainc <- env.Drain(time.Second)
+	}()
+	for errors.IsError(env.EmitNew("unknown", "event", 2), cells.ErrInvalidID) {
+		time.Sleep(time.Millisecond)
+	}
+	err := env.StartCell("other", &nullBehavior{})
+	assert.True(errors.IsError(err, cells.ErrStopping))
+	close(release)
+	assert.Wait(drainc, nil, time.Second)
+	assert.Wait(terminatedc, true, time.Second)
+	assert.False(env.HasCell("late"))
+
+	err = env.StartCell("other", &nullBehavior{})
+	assert.True(errors.IsError(err, cells.ErrStopping))
+}
+
+// TestEnvironmentObserveLifecycle tests the observing of the
+// lifecycle events of cells.
+func TestEnvironmentObserveLifecycle(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("observe-lifecycle")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 20)
+	registeredc := make(chan interface{}, 20)
+	cancel := env.ObserveLifecycle(func(le cells.LifecycleEvent) {
+		if le.Kind == cells.CellStarted {
+			registeredc <- env.HasCell(le.CellID)
+		}
+		sigc <- le.String()
+	})
+	panicker := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		panic("ouch")
+	}
+	assert.Nil(env.StartCell("foo", newEmitBehavior()))
+	assert.Nil(env.StartCell("bar", newSimpleBehavior(panicker)))
+	assert.Nil(env.Subscribe("foo", "bar"))
+	assert.Nil(env.EmitNew("foo", "panic", nil))
+
+	assert.Wait(sigc, `<cell "foo" started>`, time.Second)
+	assert.Wait(sigc, `<cell "bar" started>`, time.Second)
+	assert.Wait(registeredc, true, time.Second)
+	assert.Wait(registeredc, true, time.Second)
+	assert.Wait(sigc, `<cell "bar" subscribed "foo">`, time.Second)
+	assert.Wait(sigc, `<cell "bar" recovering: ouch>`, time.Second)
+	assert.Wait(sigc, `<cell "bar" recovered>`, time.Second)
+
+	assert.Nil(env.Unsubscribe("foo", "bar"))
+	assert.Nil(env.StopCell("bar"))
+	assert.Wait(sigc, `<cell "bar" unsubscribed "foo">`, time.Second)
+	assert.Wait(sigc, `<cell "bar" stopped>`, time.Second)
+
+	cancel()
+	assert.Nil(env.StopCell("foo"))
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected lifecycle event: %v", v))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestEnvironmentGroups tests working with groups of cells.
+func TestEnvironmentGroups(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("groups")
+	defer env.Stop()
+
+	sink := cells.NewEventSink(0)
+	sigc := make(chan interface{}, 10)
+	factory := func(id string) cells.Behavior {
+		return newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+			sigc <- cell.ID()
+			return event, nil
+		})
+	}
+	orders := env.Group("orders")
+	assert.Equal(orders.Name(), "orders")
+	assert.Equal(orders.ID("validator"), "orders/validator")
+	assert.Nil(orders.StartCells(factory, "validator", "pricer"))
+	assert.Nil(orders.Group("eu").StartCell("taxer", factory("")))
+	assert.Nil(env.StartCell("ordersX", newEmitBehavior()))
+	assert.Nil(env.StartCell("input", newEmitBehavior()))
+	assert.Nil(env.StartCell("output", newCollectBehavior(sink)))
+
+	assert.Equal(orders.Cells(), []string{"orders/eu/taxer", "orders/pricer", "orders/validator"})
+	assert.Equal(orders.Group("eu").Cells(), []string{"orders/eu/taxer"})
+	assert.Length(env.Group("").Cells(), 6)
+
+	// Fan-out and fan-in.
+	assert.Nil(orders.FanOut("input"))
+	assert.Nil(orders.FanIn("output"))
+	subs, err := env.Subscribers("input")
+	assert.Nil(err)
+	assert.Length(subs, 3)
+	assert.True(errors.IsError(orders.FanIn("unknown"), cells.ErrInvalidID))
+
+	assert.Nil(env.EmitNew("input", "order", 1))
+	received := map[interface{}]bool{}
+	for i := 0; i < 3; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Length(received, 3)
+	assert.True(received["orders/eu/taxer"])
+
+	assert.Nil(orders.Group("eu").Stop())
+	assert.Equal(orders.Cells(), []string{"orders/pricer", "orders/validator"})
+	assert.Nil(orders.Stop())
+	assert.Length(orders.Cells(), 0)
+	assert.True(env.HasCell("ordersX"))
+}
+
+// TestEnvironmentGroupsOwnCell tests fan-out and fan-in with
+// cells of the same group.
+func TestEnvironmentGroupsOwnCell(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("groups-own-cell")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	factory := func(id string) cells.Behavior {
+		return newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+			sigc <- cell.ID()
+			return event, nil
+		})
+	}
+	orders := env.Group("orders")
+	assert.Nil(orders.StartCells(factory, "dispatcher", "validator", "pricer"))
+
+	assert.Nil(orders.FanOut("orders/dispatcher"))
+	subs, err := env.Subscribers("orders/dispatcher")
+	assert.Nil(err)
+	assert.Equal(subs, []string{"orders/pricer", "orders/validator"})
+
+	assert.Nil(env.EmitNew("orders/dispatcher", "order", 1))
+	received := map[interface{}]bool{}
+	for i := 0; i < 3; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Length(received, 3)
+	select {
+	case v := <-sigc:
+		assert.Fail(fmt.Sprintf("unexpected event: %v", v))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Nil(orders.FanIn("orders/validator"))
+	subs, err = env.Subscribers("orders/validator")
+	assert.Nil(err)
+	assert.Length(subs, 0)
+}
+
+// TestEnvironmentSpawn tests spawning child cells from
+// a behavior.
+func TestEnvironmentSpawn(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("spawn")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	worker := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		return cells.NewEvent("result", event.Payload())
+	}
+	parent := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		switch event.Topic() {
+		case "request":
+			id, err := cell.Spawn(newSimpleBehavior(worker))
+			if err != nil {
+				return nil, err
+			}
+			return nil, cell.Environment().Emit(id, event)
+		case "result":
+			sigc <- event.Payload().String()
+		}
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("parent", newSimpleBehavior(parent)))
+
+	assert.Nil(env.EmitNew("parent", "request", "a"))
+	assert.Nil(env.EmitNew("parent", "request", "b"))
+	received := map[interface{}]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[v] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Equal(received, map[interface{}]bool{"a": true, "b": true})
+
+	children := env.Group("parent").Cells()
+	assert.Length(children, 2)
+	subs, err := env.Subscribers(children[0])
+	assert.Nil(err)
+	assert.Equal(subs, []string{"parent"})
+
+	// Stopping a child removes it, stopping the parent all others.
+	assert.Nil(env.StopCell(children[0]))
+	assert.Length(env.Group("parent").Cells(), 1)
+	assert.Nil(env.StopCell("parent"))
+	assert.Length(env.Group("parent").Cells(), 0)
+	assert.False(env.HasCell(children[1]))
+
+	// Stopping a group ignores the children already stopped
+	// with their parent.
+	assert.Nil(env.StartCell("jobs/parent", newSimpleBehavior(parent)))
+	assert.Nil(env.EmitNew("jobs/parent", "request", "c"))
+	assert.Wait(sigc, "c", time.Second)
+	assert.Length(env.Group("jobs").Cells(), 2)
+	assert.Nil(env.Group("jobs").Stop())
+	assert.Length(env.Group("jobs").Cells(), 0)
+
+	// Spawning while the parent is stopped does not block.
+	spawnc := audit.MakeSigChan()
+	spawner := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		spawnc <- true
+		time.Sleep(50 * time.Millisecond)
+		_, err := cell.Spawn(newSimpleBehavior(worker))
+		spawnc <- err
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("spawner", newSimpleBehavior(spawner)))
+	assert.Nil(env.EmitNew("spawner", "request", "d"))
+	assert.Wait(spawnc, true, time.Second)
+	stopc := audit.MakeSigChan()
+	go func() {
+		stopc <- env.StopCell("spawner")
+	}()
+	assert.WaitTested(spawnc, func(v interface{}) error {
+		assert.True(errors.IsError(v.(error), cells.ErrInvalidID))
+		return nil
+	}, time.Second)
+	assert.WaitTested(stopc, func(v interface{}) error {
+		assert.Nil(v)
+		return nil
+	}, time.Second)
+}
+
+// TestEnvironmentSpawnWhileStopping tests that children whose
+// parent is stopped while they initialize are stopped silently and
+// may use the environment when terminating.
+func TestEnvironmentSpawnWhileStopping(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("spawn-while-stopping")
+	defer env.Stop()
+
+	lifecyclec := make(chan interface{}, 10)
+	env.ObserveLifecycle(func(le cells.LifecycleEvent) {
+		if strings.HasPrefix(le.CellID, "spawner/") {
+			lifecyclec <- le.String()
+		}
+	})
+	release := make(chan struct{})
+	terminatedc := audit.MakeSigChan()
+	spawnc := audit.MakeSigChan()
+	spawner := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		_, err := cell.Spawn(&unsubscribingBehavior{
+			release:    release,
+			emitterID:  "spawner",
+			terminated: terminatedc,
+		})
+		spawnc <- err
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("spawner", newSimpleBehavior(spawner)))
+	assert.Nil(env.EmitNew("spawner", "request", nil))
+	time.Sleep(20 * time.Millisecond)
+	stopc := audit.MakeSigChan()
+	go func() {
+		stopc <- env.StopCell("spawner")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	assert.WaitTested(terminatedc, func(v interface{}) error {
+		assert.True(errors.IsError(v.(error), cells.ErrInvalidID))
+		return nil
+	}, time.Second)
+	assert.WaitTested(spawnc, func(v interface{}) error {
+		assert.True(errors.IsError(v.(error), cells.ErrInvalidID))
+		return nil
+	}, time.Second)
+	assert.Wait(stopc, nil, time.Second)
+	select {
+	case v := <-lifecyclec:
+		assert.Fail(fmt.Sprintf("unexpected lifecycle event: %v", v))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestEnvironmentBlockingInit tests that initializing a cell
+// does not block the environment.
+func TestEnvironmentBlockingInit(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("blocking-init")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	assert.Nil(env.StartCell("collector", newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- event.Topic()
+		return nil, nil
+	})))
+	release := make(chan struct{})
+	startedc := audit.MakeSigChan()
+	go func() {
+		startedc <- env.StartCell("blocking", &blockingInitBehavior{release, nil})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Other cells are working while "blocking" initializes.
+	assert.False(env.HasCell("blocking"))
+	assert.ErrorMatch(env.StartCell("blocking", &nullBehavior{}), ".*already registered.*")
+	assert.Nil(env.EmitNew("collector", "foo", "bar"))
+	assert.Nil(env.StartCell("other", &nullBehavior{}))
+	assert.Nil(env.Subscribe("other", "collector"))
+	assert.Wait(sigc, "foo", time.Second)
+
+	close(release)
+	assert.Wait(startedc, nil, time.Second)
+	assert.True(env.HasCell("blocking"))
+}
+
+// TestEnvironmentEmitDuringInit tests that events emitted to
+// a cell during its initialization are processed afterwards.
+func TestEnvironmentEmitDuringInit(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("emit-during-init")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	assert.Nil(env.StartCell("self", &selfEmittingInitBehavior{sigc}))
+	assert.Wait(sigc, nil, time.Second)
+	assert.Wait(sigc, "init", time.Second)
+
+	// Events of failed initializations are dropped.
+	assert.ErrorMatch(env.StartCell("failing", &selfEmittingInitBehavior{nil}), ".*cannot initialize.*")
+	assert.False(env.HasCell("failing"))
+	assert.Nil(env.StartCell("failing", &nullBehavior{}))
+}
+
+// TestEnvironmentRingQueues tests the delivery of events
+// with ring queues.
+func TestEnvironmentRingQueues(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("ring-queues")
+	defer env.Stop()
+
+	assert.Nil(env.Configure(cells.RingQueues(1000)))
+	sigc := make(chan interface{}, 1000)
+	assert.Nil(env.StartCell("collector", newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		sigc <- event.Payload().String()
+		return nil, nil
+	})))
+	for i := 0; i < 500; i++ {
+		assert.Nil(env.EmitNew("collector", "count", strconv.Itoa(i)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Wait(sigc, strconv.Itoa(i), time.Second)
+	}
+
+	// Emitting into a blocked cell fills its queue.
+	release := make(chan struct{})
+	cellc := make(chan cells.Cell, 1)
+	ownc := audit.MakeSigChan()
+	assert.Nil(env.StartCell("blocked", newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		select {
+		case cellc <- cell:
+		default:
+		}
+		<-release
+		if event.Topic() == "own" {
+			ownc <- true
+		}
+		return nil, nil
+	})))
+	var err error
+	for i := 0; i < 2000 && err == nil; i++ {
+		err = env.EmitNew("blocked", "count", i)
+	}
+	assert.True(errors.IsError(err, cells.ErrQueueFull))
+
+	// Events of other cells are dead letters without
+	// letting the emitting cell fail.
+	blocked := <-cellc
+	deadc := make(chan interface{}, 4000)
+	assert.Nil(env.StartCell("dead-letters", newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		var dl cells.DeadLetter
+		if err := event.Payload().Unmarshal(&dl); err != nil {
+			return nil, err
+		}
+		deadc <- dl.Reason + " " + dl.CellID + " " + dl.Topic
+		return nil, nil
+	})))
+	assert.Nil(env.Configure(cells.DeadLetters("dead-letters")))
+	ci := cells.InspectCell(blocked.Environment(), "collector")
+	assert.Nil(env.Subscribe("collector", "blocked"))
+	overflow, err := cells.NewEvent("overflow", nil)
+	assert.Nil(err)
+	// The queue backend may still take events, so emit
+	// more than fit into the queue.
+	for i := 0; i < 4000; i++ {
+		assert.Nil(ci.Emit(overflow))
+	}
+	assert.Wait(deadc, "queue-full blocked overflow", time.Second)
+
+	// Events of the cell to itself are accepted anyway.
+	assert.Nil(blocked.Environment().EmitNew(blocked.ID(), "own", nil))
+	close(release)
+	assert.Wait(ownc, true, time.Second)
+}
+
+// TestEnvironmentBatches tests processing events in batches.
+func TestEnvironmentBatches(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	env := cells.NewEnvironment("batches")
+	defer env.Stop()
+
+	sigc := make(chan interface{}, 10)
+	deadLetters := func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		var dl cells.DeadLetter
+		if err := event.Payload().Unmarshal(&dl); err != nil {
+			return nil, err
+		}
+		sigc <- dl.Reason + " " + dl.Topic
+		return nil, nil
+	}
+	assert.Nil(env.StartCell("batch", &batchBehavior{sigc, 50 * time.Millisecond}))
+	assert.Nil(env.StartCell("dead-letters", newSimpleBehavior(deadLetters)))
+	assert.Nil(env.Configure(cells.DeadLetters("dead-letters")))
+
+	// Batches are full or their latency is reached.
+	for _, topic := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		assert.Nil(env.EmitNew("batch", topic, nil))
+	}
+	assert.Wait(sigc, []string{"a", "b", "c", "d", "e"}, time.Second)
+	assert.Wait(sigc, []string{"f", "g"}, time.Second)
+
+	// After a panic the failed event is a dead letter and
+	// the following ones are processed.
+	for _, topic := range []string{"a", "b", panicTopic, "c", "d"} {
+		assert.Nil(env.EmitNew("batch", topic, nil))
+	}
+	assert.Wait(sigc, []string{"a", "b"}, time.Second)
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[fmt.Sprint(v)] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Equal(received, map[string]bool{"batch-failed " + panicTopic: true, "[c d]": true})
+
+	// Same after an error.
+	for _, topic := range []string{"a", ouchTopic, "b"} {
+		assert.Nil(env.EmitNew("batch", topic, nil))
+	}
+	assert.Wait(sigc, []string{"a"}, time.Second)
+	received = map[string]bool{}
+	for i := 0; i < 2; i++ {
+		assert.WaitTested(sigc, func(v interface{}) error {
+			received[fmt.Sprint(v)] = true
+			return nil
+		}, time.Second)
+	}
+	assert.Equal(received, map[string]bool{"batch-failed " + ouchTopic: true, "[b]": true})
+
+	// The last batch is processed when stopping. If it fails the
+	// failed event is a dead letter and the cell terminates anyway.
+	// Here emitting returns when the cell collected the event.
+	assert.Nil(env.Configure(cells.HandOverQueues()))
+	for _, failTopic := range []string{ouchTopic, panicTopic} {
+		assert.Nil(env.StartCell("last-batch", &batchBehavior{sigc, time.Minute}))
+		for _, topic := range []string{"a", failTopic} {
+			assert.Nil(env.EmitNew("last-batch", topic, nil))
+		}
+		assert.Nil(env.StopCell("last-batch"))
+		assert.Wait(sigc, []string{"a"}, time.Second)
+		received = map[string]bool{}
+		for i := 0; i < 2; i++ {
+			assert.WaitTested(sigc, func(v interface{}) error {
+				received[fmt.Sprint(v)] = true
+				return nil
+			}, time.Second)
+		}
+		assert.Equal(received, map[string]bool{"batch-failed " + failTopic: true, "terminated": true})
+	}
+
+	// Batch errors can be checked as usual.
+	err := cells.NewBatchError(1, fmt.Errorf("ouch"))
+	assert.True(errors.IsError(err, cells.ErrBatchFailed))
+	assert.ErrorMatch(err, ".*processing of event 1 of batch failed: ouch.*")
+}
+
 // TestEnvironmentScenario tests creating and using the
 // environment in a simple way.
 func TestEnvironmentScenario(t *testing.T) {
@@ -314,4 +1228,155 @@ func BenchmarkSmpleEmitStandardMonitoring(b *testing.B) {
 	}
 }
 
+// BenchmarkInMemoryQueue is emitting events into the default
+// queue while receiving them.
+func BenchmarkInMemoryQueue(b *testing.B) {
+	benchmarkQueue(b, cells.NewInMemoryQueue())
+}
+
+// BenchmarkRingQueue is emitting events into a ring queue
+// while receiving them.
+func BenchmarkRingQueue(b *testing.B) {
+	benchmarkQueue(b, cells.NewRingQueue(1024))
+}
+
+// benchmarkQueue is emitting events into the queue while
+// receiving them. The number of queued events is limited.
+func benchmarkQueue(b *testing.B, queue cells.Queue) {
+	defer queue.Close()
+	event, _ := cells.NewEvent("foo", "bar")
+	queuedc := make(chan struct{}, 512)
+	donec := make(chan struct{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			<-queue.Events()
+			<-queuedc
+		}
+		close(donec)
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queuedc <- struct{}{}
+		queue.Emit(event)
+	}
+	<-donec
+}
+
+// BenchmarkEnvironmentDefaultQueues is emitting events to a
+// cell with the default queue while it processes them.
+func BenchmarkEnvironmentDefaultQueues(b *testing.B) {
+	benchmarkEnvironmentQueues(b, cells.RingQueues(0))
+}
+
+// BenchmarkEnvironmentRingQueues is emitting events to a cell
+// with a ring queue while it processes them.
+func BenchmarkEnvironmentRingQueues(b *testing.B) {
+	benchmarkEnvironmentQueues(b, cells.RingQueues(1024))
+}
+
+// benchmarkEnvironmentQueues is emitting events to a cell of an
+// environment configured with the option while it processes them.
+// The number of queued events is limited.
+func benchmarkEnvironmentQueues(b *testing.B, option cells.Option) {
+	monitoring.SetBackend(monitoring.NewNullBackend())
+	env := cells.NewEnvironment("environment-queues")
+	defer env.Stop()
+
+	env.Configure(option)
+	queuedc := make(chan struct{}, 512)
+	donec := make(chan struct{})
+	processed := 0
+	env.StartCell("counter", newSimpleBehavior(func(cell cells.Cell, event cells.Event) (cells.Event, error) {
+		<-queuedc
+		processed++
+		if processed == b.N {
+			close(donec)
+		}
+		return nil, nil
+	}))
+	event, _ := cells.NewEvent("foo", "bar")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queuedc <- struct{}{}
+		env.Emit("counter", event)
+	}
+	<-donec
+}
+
+// BenchmarkFanOutEmit is emitting events from one cell
+// to ten subscribers.
+func BenchmarkFanOutEmit(b *testing.B) {
+	monitoring.SetBackend(monitoring.NewNullBackend())
+	env := cells.NewEnvironment("fan-out-emit")
+	defer env.Stop()
+
+	env.StartCell("emitter", newEmitBehavior())
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("null-%d", i)
+		env.StartCell(id, &nullBehavior{})
+		env.Subscribe("emitter", id)
+	}
+	emitter := cells.InspectCell(env, "emitter")
+	event, _ := cells.NewEvent("foo", "bar")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(event)
+	}
+}
+
+// BenchmarkManyCellsEmit is concurrently emitting to
+// 100,000 cells.
+func BenchmarkManyCellsEmit(b *testing.B) {
+	monitoring.SetBackend(monitoring.NewNullBackend())
+	env := cells.NewEnvironment("many-cells-emit")
+	defer env.Stop()
+
+	const count = 100000
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("null-%d", i)
+		env.StartCell(ids[i], &nullBehavior{})
+	}
+
+	event, _ := cells.NewEvent("foo", "bar")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(count)
+		for pb.Next() {
+			env.Emit(ids[i%count], event)
+			i++
+		}
+	})
+}
+
+// BenchmarkManyCellsStart is concurrently starting cells
+// while emitting to them.
+func BenchmarkManyCellsStart(b *testing.B) {
+	monitoring.SetBackend(monitoring.NewNullBackend())
+	env := cells.NewEnvironment("many-cells-start")
+	defer env.Stop()
+
+	env.StartCell("null", &nullBehavior{})
+
+	event, _ := cells.NewEvent("foo", "bar")
+	var counter int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := atomic.AddInt64(&counter, 1)
+			id := fmt.Sprintf("null-%d", n)
+			env.StartCell(id, &nullBehavior{})
+			env.Emit("null", event)
+		}
+	})
+}
+
 // EOF
@@ -33,13 +33,14 @@ const (
 
 // Standard topics.
 const (
-	TopicCollected = "collected"
-	TopicCounted   = "counted"
-	TopicProcess   = "process"
-	TopicProcessed = "processed"
-	TopicReset     = "reset"
-	TopicStatus    = "status"
-	TopicTick      = "tick"
+	TopicCollected  = "collected"
+	TopicCounted    = "counted"
+	TopicDeadLetter = "dead-letter"
+	TopicProcess    = "process"
+	TopicProcessed  = "processed"
+	TopicReset      = "reset"
+	TopicStatus     = "status"
+	TopicTick       = "tick"
 )
 
 // Standard constant payloads.
@@ -70,6 +71,11 @@ const (
 	// event into a cells event buffer before a timeout
 	// error is returned to the emitter.
 	maxEmitTimeout = 30 * time.Second
+
+	// defaultBatchSize and defaultBatchLatency control
+	// the default collecting of events for batches.
+	defaultBatchSize    = 100
+	defaultBatchLatency = 100 * time.Millisecond
 )
 
 // EOF
@@ -27,7 +27,13 @@
 //
 // so that events emitted by the "foo" cell during the processing of
 // events will be received by the "bar" cell. Each cell can have
-// multiple cells subscibed.
+// multiple cells subscibed. With
+//
+//    env.SubscribeTopics("foo", "baz", "sensor.*.temperature")
+//
+// the "baz" cell only receives those events whose topics match
+// one of the patterns. SubscribeFiltered() additionally allows to
+// pass a SubscriptionFilter checking the events, e.g. their payloads.
 //
 // Events from the outside are emitted using
 //
@@ -40,11 +46,77 @@
 //        "KeyB": true,
 //    })
 //
+// Payloads created with NewTypedPayload() pass their Go value to all
+// receivers without copying and are only marshalled when needed. The
+// value is retrieved with
+//
+//     order, err := cells.PayloadAs[*Order](event.Payload())
+//
+// which also decodes other payloads only once per type.
+//
+// Events can also be emitted later with
+//
+//     scheduled, err := env.EmitAfter("foo", 5*time.Minute, myEvent)
+//
+// or EmitAt() for a given time. The returned handle allows to cancel
+// the emitting, SnapshotScheduled() and RestoreScheduled() persist
+// the pending events.
+//
+// Events created with NewEventWithDeadline() or NewEventWithTTL() are
+// not processed anymore after they expired. Instead they are counted
+// and, if configured with
+//
+//     env.Configure(cells.DeadLetters("dead-letters"))
+//
+// emitted as DeadLetter to the given cell.
+//
+// The option DetectCycles() lets subscriptions creating cycles of
+// cells fail. Deliberate cycles can be protected with MaxHops(). Each
+// emitting of an event counts as hop, events emitted during the
+// processing inherit the hops of the processed event. This also
+// applies to events emitted concurrently by other goroutines of the
+// behavior. Events with too many hops are handled like expired ones.
+//
+// Environments created with NewEnvironmentWithContext() are stopped
+// when the context is cancelled. Behaviors can observe this using the
+// context of their cell. StopWithContext() bounds the time for stopping.
+// Drain() stops gracefully, so that the events already queued and those
+// emitted to subscribers meanwhile are processed. Events emitted via the
+// environment are rejected with ErrStopping, scheduled ones are dropped.
+// This also applies to behaviors emitting to their own cells, e.g. from
+// timers, so state they have not emitted yet is lost when they terminate.
+// The cells are stopped in the order of their subscriptions from sources
+// to sinks, each after processing its pending events and those emitted by
+// cells stopped before. If the timeout is reached the remaining cells are
+// stopped immediately and the lost events are reported with ErrEventsLost.
+//
+// Cell IDs can be organized in groups like "orders/validator". The
+// Group returned by env.Group("orders") allows to start, stop, and
+// subscribe all cells of this group together.
+//
+// Observers registered with ObserveLifecycle() are informed when cells
+// are started, stopped, recovered, subscribed, or unsubscribed.
+//
+// With the option RingQueues() the cells started afterwards use
+// queues based on ring buffers. They deliver events with less
+// overhead, but their capacity is fixed. Events emitted by cells to
+// full subscribers become dead letters, only events behaviors emit
+// to their own cells are accepted beyond it.
+//
 // Behaviors have to implement the cells.Behavior interface. Here
 // the Init() method is called with a cells.Context. This can be
 // used inside the ProcessEvent() method to emit events to subscribers
 // or directly to other cells of the environment.
 //
+// Behaviors additionally implementing cells.BatchBehavior receive the
+// events in batches with ProcessEvents(). Their size and latency can be
+// set with cells.BehaviorBatching. Failing events are reported with
+// NewBatchError() and handled as dead letters.
+//
+// A behavior may also spawn child cells with cell.Spawn(), e.g. one
+// per request. The parent is subscribed to its children, they are
+// part of its group, and they are stopped together with the parent.
+//
 // Sometimes it's needed to directly communicate with a cell to retrieve
 // information. In this case the method
 //
@@ -61,6 +133,10 @@
 //    }
 //
 // Instructions without a response are simply done by emitting an event.
+//
+// Version 6 extends the interfaces Event by Deadline() and Hops() and Cell
+// by Context(), Spawn(), and Children(). So own implementations have to be
+// extended too, those embedding the interfaces are not affected.
 package cells
 
 // EOF
@@ -12,10 +12,16 @@ package cells
 //--------------------
 
 import (
+	"context"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/tideland/golib/errors"
 	"github.com/tideland/golib/identifier"
 	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/monitoring"
 )
 
 //--------------------
@@ -24,12 +30,32 @@ import (
 
 // Environment implements the Environment interface.
 type environment struct {
-	id    string
-	cells *registry
+	hops         int64
+	mutex        sync.RWMutex
+	id           string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stopped      bool
+	draining     bool
+	cells        *registry
+	timers       *timers
+	lifecycle    *lifecycle
+	deadLetterID string
+	newQueue     func() Queue
 }
 
 // NewEnvironment creates a new environment.
 func NewEnvironment(idParts ...interface{}) Environment {
+	return NewEnvironmentWithContext(context.Background(), idParts...)
+}
+
+// NewEnvironmentWithContext creates a new environment which is
+// stopped when the context is cancelled. The cells get contexts
+// derived from it. Until then the environment is referenced by the
+// goroutine watching the context, so it is not finalized when it is
+// dropped. It has to be stopped with Stop() or the context, otherwise
+// its cells keep running.
+func NewEnvironmentWithContext(ctx context.Context, idParts ...interface{}) Environment {
 	var id string
 	if len(idParts) == 0 {
 		id = identifier.NewUUID().String()
@@ -40,6 +66,12 @@ func NewEnvironment(idParts ...interface{}) Environment {
 		id:    id,
 		cells: newRegistry(),
 	}
+	env.ctx, env.cancel = context.WithCancel(ctx)
+	env.timers = newTimers(env)
+	env.lifecycle = newLifecycle()
+	if ctx.Done() != nil {
+		go env.watchContext()
+	}
 	runtime.SetFinalizer(env, (*environment).Stop)
 	logger.Infof("cells environment %q started", env.ID())
 	return env
@@ -50,6 +82,18 @@ func (env *environment) ID() string {
 	return env.id
 }
 
+// Configure implements the Environment interface.
+func (env *environment) Configure(options ...Option) error {
+	env.mutex.Lock()
+	defer env.mutex.Unlock()
+	for _, option := range options {
+		if err := option(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // StartCell implements the Environment interface.
 func (env *environment) StartCell(id string, behavior Behavior) error {
 	return env.cells.startCell(env, id, behavior)
@@ -66,16 +110,36 @@ func (env *environment) HasCell(id string) bool {
 	return err == nil
 }
 
+// Group implements the Environment interface.
+func (env *environment) Group(name string) Group {
+	return newGroup(env, name)
+}
+
 // Subscribe implements the Environment interface.
 func (env *environment) Subscribe(emitterID string, subscriberIDs ...string) error {
 	return env.cells.subscribe(emitterID, subscriberIDs...)
 }
 
+// SubscribeTopics implements the Environment interface.
+func (env *environment) SubscribeTopics(emitterID, subscriberID string, patterns ...string) error {
+	return env.cells.subscribeFiltered(emitterID, subscriberID, nil, patterns...)
+}
+
+// SubscribeFiltered implements the Environment interface.
+func (env *environment) SubscribeFiltered(emitterID, subscriberID string, filter SubscriptionFilter, patterns ...string) error {
+	return env.cells.subscribeFiltered(emitterID, subscriberID, filter, patterns...)
+}
+
 // Subscribers implements the Environment interface.
 func (env *environment) Subscribers(id string) ([]string, error) {
 	return env.cells.subscribers(id)
 }
 
+// Subscriptions implements the Environment interface.
+func (env *environment) Subscriptions(id string) (map[string][]string, error) {
+	return env.cells.subscriptions(id)
+}
+
 // Unsubscribe implements the Environment interface.
 func (env *environment) Unsubscribe(emitterID string, subscriberIDs ...string) error {
 	return env.cells.unsubscribe(emitterID, subscriberIDs...)
@@ -83,7 +147,10 @@ func (env *environment) Unsubscribe(emitterID string, subscriberIDs ...string) e
 
 // Emit implements the Environment interface.
 func (env *environment) Emit(id string, event Event) error {
-	c, err := env.cells.cell(id)
+	if env.isDraining() {
+		return errors.New(ErrStopping, errorMessages, "environment "+env.id)
+	}
+	c, err := env.cells.receiver(id)
 	if err != nil {
 		return err
 	}
@@ -99,19 +166,149 @@ func (env *environment) EmitNew(id, topic string, payload interface{}) error {
 	return env.Emit(id, event)
 }
 
+// EmitAt implements the Environment interface.
+func (env *environment) EmitAt(id string, at time.Time, event Event) (Scheduled, error) {
+	if env.isDraining() {
+		return nil, errors.New(ErrStopping, errorMessages, "environment "+env.id)
+	}
+	if _, err := env.cells.receiver(id); err != nil {
+		return nil, err
+	}
+	return env.timers.schedule(id, at, event)
+}
+
+// EmitAfter implements the Environment interface.
+func (env *environment) EmitAfter(id string, after time.Duration, event Event) (Scheduled, error) {
+	return env.EmitAt(id, time.Now().Add(after), event)
+}
+
+// ObserveLifecycle implements the Environment interface.
+func (env *environment) ObserveLifecycle(observer LifecycleObserver) func() {
+	return env.lifecycle.observe(observer)
+}
+
+// SnapshotScheduled implements the Environment interface.
+func (env *environment) SnapshotScheduled() ([]byte, error) {
+	return env.timers.snapshot()
+}
+
+// RestoreScheduled implements the Environment interface.
+func (env *environment) RestoreScheduled(snapshot []byte) error {
+	return env.timers.restore(snapshot)
+}
+
 // Stop implements the Environment interface.
 func (env *environment) Stop() error {
+	env.mutex.Lock()
+	if env.stopped {
+		env.mutex.Unlock()
+		return nil
+	}
+	env.stopped = true
+	env.mutex.Unlock()
 	runtime.SetFinalizer(env, nil)
-	if err := env.cells.stop(); err != nil {
+	env.cancel()
+	if err := env.timers.stop(); err != nil {
+		return err
+	}
+	err := env.cells.stop()
+	// Dispatch the lifecycle events of the stopping too.
+	if lcErr := env.lifecycle.stop(); lcErr != nil && err == nil {
+		err = lcErr
+	}
+	if err != nil {
 		return err
 	}
 	logger.Infof("cells environment %q terminated", env.ID())
 	return nil
 }
 
+// maxHops returns the configured maximum number of hops. It is
+// read atomically as it is needed for each event.
+func (env *environment) maxHops() int {
+	return int(atomic.LoadInt64(&env.hops))
+}
+
+// deadLetter counts an event not processed by the cell with
+// the given ID and emits it to the dead letter cell if configured.
+func (env *environment) deadLetter(id, reason string, event Event) {
+	monitoring.IncrVariable(identifier.Identifier("cells", env.id, "dead-letters", reason))
+	env.mutex.RLock()
+	deadLetterID := env.deadLetterID
+	env.mutex.RUnlock()
+	if deadLetterID == "" || deadLetterID == id {
+		return
+	}
+	err := env.EmitNew(deadLetterID, TopicDeadLetter, DeadLetter{
+		Reason:    reason,
+		CellID:    id,
+		Topic:     event.Topic(),
+		Timestamp: event.Timestamp(),
+		Payload:   event.Payload().Bytes(),
+	})
+	if err != nil {
+		logger.Errorf("cannot emit dead letter of cell %q: %v", id, err)
+	}
+}
+
+// Drain implements the Environment interface.
+func (env *environment) Drain(timeout time.Duration) error {
+	env.mutex.Lock()
+	if env.stopped || env.draining {
+		env.mutex.Unlock()
+		return nil
+	}
+	env.draining = true
+	env.mutex.Unlock()
+	logger.Infof("cells environment %q drains", env.id)
+	if err := env.timers.stop(); err != nil {
+		return err
+	}
+	err := env.cells.drain(time.Now().Add(timeout))
+	if stopErr := env.Stop(); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// isDraining returns true if the environment does not
+// accept events from the outside anymore.
+func (env *environment) isDraining() bool {
+	env.mutex.RLock()
+	defer env.mutex.RUnlock()
+	return env.draining
+}
+
+// StopWithContext implements the Environment interface.
+func (env *environment) StopWithContext(ctx context.Context) error {
+	donec := make(chan error, 1)
+	go func() {
+		donec <- env.Stop()
+	}()
+	select {
+	case err := <-donec:
+		return err
+	case <-ctx.Done():
+		return errors.Annotate(ctx.Err(), ErrTimeout, errorMessages, "stopping environment "+env.id)
+	}
+}
+
+// watchContext stops the environment when the context
+// is cancelled. Stopping twice does no harm.
+func (env *environment) watchContext() {
+	<-env.ctx.Done()
+	if err := env.Stop(); err != nil {
+		logger.Errorf("cells environment %q stopped by context with error: %v", env.id, err)
+	}
+}
+
 // createQueue is a factory for the configured type of queues.
 func (env *environment) createQueue() Queue {
-	// Currently only one queue type is supported.
+	env.mutex.RLock()
+	defer env.mutex.RUnlock()
+	if env.newQueue != nil {
+		return env.newQueue()
+	}
 	return newInMemoryQueue()
 }
 
@@ -35,6 +35,11 @@ const (
 	ErrInactive
 	ErrStopping
 	ErrTimeout
+	ErrInvalidPattern
+	ErrCycle
+	ErrEventsLost
+	ErrQueueFull
+	ErrBatchFailed
 )
 
 // Error messages of the cells package.
@@ -53,6 +58,11 @@ var errorMessages = map[int]string{
 	ErrInactive:          "cell %q is inactive",
 	ErrStopping:          "%s is stopping",
 	ErrTimeout:           "needed too long for %v",
+	ErrInvalidPattern:    "invalid topic pattern %q",
+	ErrCycle:             "subscribing %q to %q would create a cycle",
+	ErrEventsLost:        "cell %q lost %d events",
+	ErrQueueFull:         "event queue is full with %d events",
+	ErrBatchFailed:       "processing of event %d of batch failed",
 }
 
 //--------------------
@@ -65,4 +75,43 @@ func NewCannotRecoverError(id string, err interface{}) error {
 	return errors.New(ErrCannotRecover, errorMessages, id, err)
 }
 
+//--------------------
+// BATCH ERROR
+//--------------------
+
+// batchError is annotated by the error returned by NewBatchError()
+// to tell which event of a batch failed.
+type batchError struct {
+	index int
+	err   error
+}
+
+// NewBatchError creates an error for BatchBehavior.ProcessEvents()
+// telling that the processing of the event with the given index
+// of the batch failed. It has the code ErrBatchFailed.
+func NewBatchError(index int, err error) error {
+	return errors.Annotate(&batchError{index, err}, ErrBatchFailed, errorMessages, index)
+}
+
+// Error implements the error interface.
+func (e *batchError) Error() string {
+	if e.err == nil {
+		return "unknown reason"
+	}
+	return e.err.Error()
+}
+
+// batchErrorIndex returns the index of the failed event of a batch
+// if the reason is an error created with NewBatchError().
+func batchErrorIndex(reason interface{}) (int, bool) {
+	err, ok := reason.(error)
+	if !ok || !errors.IsError(err, ErrBatchFailed) {
+		return 0, false
+	}
+	if e, ok := errors.Annotated(err).(*batchError); ok {
+		return e.index, true
+	}
+	return 0, false
+}
+
 // EOF
@@ -34,6 +34,15 @@ type Event interface {
 
 	// Payload returns the payload of the event.
 	Payload() Payload
+
+	// Deadline returns the time after which the event expires
+	// and will not be processed anymore. The boolean is false
+	// if the event does not expire.
+	Deadline() (time.Time, bool)
+
+	// Hops returns how often the event or the events leading to
+	// it have been emitted by cells.
+	Hops() int
 }
 
 // event implements the Event interface.
@@ -41,6 +50,8 @@ type event struct {
 	timestamp time.Time
 	topic     string
 	payload   Payload
+	deadline  time.Time
+	hops      int
 }
 
 // NewEvent creates a new event with the given topic and payload.
@@ -59,6 +70,23 @@ func NewEvent(topic string, payload interface{}) (Event, error) {
 	}, nil
 }
 
+// NewEventWithDeadline creates a new event with the given topic
+// and payload expiring at the deadline.
+func NewEventWithDeadline(topic string, payload interface{}, deadline time.Time) (Event, error) {
+	e, err := NewEvent(topic, payload)
+	if err != nil {
+		return nil, err
+	}
+	e.(*event).deadline = deadline.UTC()
+	return e, nil
+}
+
+// NewEventWithTTL creates a new event with the given topic and
+// payload expiring after the time to live.
+func NewEventWithTTL(topic string, payload interface{}, ttl time.Duration) (Event, error) {
+	return NewEventWithDeadline(topic, payload, time.Now().Add(ttl))
+}
+
 // Timestamp implements the Event interface.
 func (e *event) Timestamp() time.Time {
 	return e.timestamp
@@ -78,6 +106,16 @@ func (e *event) Payload() Payload {
 	return e.payload
 }
 
+// Deadline implements the Event interface.
+func (e *event) Deadline() (time.Time, bool) {
+	return e.deadline, !e.deadline.IsZero()
+}
+
+// Hops implements the Event interface.
+func (e *event) Hops() int {
+	return e.hops
+}
+
 // String implements the Stringer interface.
 func (e *event) String() string {
 	timeStr := e.timestamp.Format(time.RFC3339Nano)
@@ -88,4 +126,29 @@ func (e *event) String() string {
 	return fmt.Sprintf("<timestamp: %s / topic: '%s' / payload: %s>", timeStr, e.topic, payloadStr)
 }
 
+// hoppedEvent sets the hops of other event implementations.
+type hoppedEvent struct {
+	Event
+	hops int
+}
+
+// Hops implements the Event interface.
+func (e *hoppedEvent) Hops() int {
+	return e.hops
+}
+
+// withHops returns a copy of the event with the given hops.
+func withHops(e Event, hops int) Event {
+	switch te := e.(type) {
+	case *event:
+		he := *te
+		he.hops = hops
+		return &he
+	case *hoppedEvent:
+		return &hoppedEvent{te.Event, hops}
+	default:
+		return &hoppedEvent{e, hops}
+	}
+}
+
 // EOF
@@ -44,6 +44,31 @@ func TestEvent(t *testing.T) {
 
 	_, err = cells.NewEvent("yadda", nil)
 	assert.Nil(err)
+
+	_, ok := event.Deadline()
+	assert.False(ok)
+	assert.Equal(event.Hops(), 0)
+}
+
+// TestEventDeadline tests the construction of expiring events.
+func TestEventDeadline(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	now := time.Now().UTC()
+
+	event, err := cells.NewEventWithDeadline("foo", "bar", now.Add(time.Minute))
+	assert.Nil(err)
+	deadline, ok := event.Deadline()
+	assert.True(ok)
+	assert.Equal(deadline, now.Add(time.Minute))
+
+	event, err = cells.NewEventWithTTL("foo", "bar", time.Minute)
+	assert.Nil(err)
+	deadline, ok = event.Deadline()
+	assert.True(ok)
+	assert.True(deadline.Sub(now) >= time.Minute)
+
+	_, err = cells.NewEventWithTTL("", "bar", time.Minute)
+	assert.True(errors.IsError(err, cells.ErrNoTopic))
 }
 
 // TestPayload tests the payload creation and access.
@@ -84,4 +109,73 @@ func TestPayload(t *testing.T) {
 	assert.Equal(same, payload)
 }
 
+// TestTypedPayload tests the typed payload and the access
+// to the values of payloads.
+func TestTypedPayload(t *testing.T) {
+	type order struct {
+		ID    int
+		Items []string
+	}
+
+	assert := audit.NewTestingAssertion(t, true)
+
+	in := &order{
+		ID:    4711,
+		Items: []string{"foo", "bar"},
+	}
+	payload := cells.NewTypedPayload(in)
+	event, err := cells.NewEvent("order", payload)
+	assert.Nil(err)
+
+	// Values are shared, not copied.
+	out, err := cells.PayloadAs[*order](event.Payload())
+	assert.Nil(err)
+	assert.True(out == in)
+	var outp *order
+	assert.Nil(event.Payload().Unmarshal(&outp))
+	assert.True(outp == in)
+
+	// Lazy marshalling for other types and the bytes.
+	var outv order
+	assert.Nil(event.Payload().Unmarshal(&outv))
+	assert.Equal(outv, *in)
+	assert.Equal(event.Payload().String(), `{"ID":4711,"Items":["foo","bar"]}`)
+	outm, err := cells.PayloadAs[map[string]interface{}](event.Payload())
+	assert.Nil(err)
+	assert.Equal(outm["ID"], 4711.0)
+
+	// Marshalled payloads are decoded once per type.
+	payload, err = cells.NewPayload(in)
+	assert.Nil(err)
+	first, err := cells.PayloadAs[*order](payload)
+	assert.Nil(err)
+	assert.Equal(first, in)
+	second, err := cells.PayloadAs[*order](payload)
+	assert.Nil(err)
+	assert.True(first == second)
+	_, err = cells.PayloadAs[int](payload)
+	assert.True(errors.IsError(err, cells.ErrUnmarshal))
+
+	// Strings and byte slices are raw like with NewPayload().
+	payload = cells.NewTypedPayload("foo")
+	assert.Equal(payload.String(), "foo")
+	assert.Equal(payload.Bytes(), []byte("foo"))
+	payload = cells.NewTypedPayload([]byte("bar"))
+	assert.Equal(payload.String(), "bar")
+	payload, err = cells.NewPayload("baz")
+	assert.Nil(err)
+	outstr, err := cells.PayloadAs[string](payload)
+	assert.Nil(err)
+	assert.Equal(outstr, "baz")
+	outbytes, err := cells.PayloadAs[[]byte](payload)
+	assert.Nil(err)
+	assert.Equal(outbytes, []byte("baz"))
+
+	// Failing marshalling.
+	payload = cells.NewTypedPayload(make(chan int))
+	var outs string
+	assert.True(errors.IsError(payload.Unmarshal(&outs), cells.ErrMarshal))
+	assert.Equal(payload.Len(), 0)
+}
+
 // EOF
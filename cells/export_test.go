@@ -38,7 +38,15 @@ type CellInsight struct {
 }
 
 func InspectCell(env Environment, id string) *CellInsight {
-	e := env.(*environment)
+	var e *environment
+	switch te := env.(type) {
+	case *environment:
+		e = te
+	case *cellEnvironment:
+		e = te.environment
+	default:
+		panic("unknown environment")
+	}
 	c, err := e.cells.cell(id)
 	if err != nil {
 		panic(err)
@@ -58,4 +66,48 @@ func (ci *CellInsight) RecoveringDuration() time.Duration {
 	return ci.c.recoveringDuration
 }
 
+func (ci *CellInsight) Emit(event Event) error {
+	return ci.c.Emit(event)
+}
+
+//--------------------
+// QUEUES
+//--------------------
+
+func NewInMemoryQueue() Queue {
+	return newInMemoryQueue()
+}
+
+func NewRingQueue(capacity int) Queue {
+	return newRingQueue(capacity)
+}
+
+// HandOverQueues lets cells started afterwards use queues
+// which return from emitting when the cell received the event.
+func HandOverQueues() Option {
+	return func(env *environment) error {
+		env.newQueue = func() Queue {
+			return &handOverQueue{make(chan Event)}
+		}
+		return nil
+	}
+}
+
+type handOverQueue struct {
+	eventc chan Event
+}
+
+func (q *handOverQueue) Emit(event Event) error {
+	q.eventc <- event
+	return nil
+}
+
+func (q *handOverQueue) Events() <-chan Event {
+	return q.eventc
+}
+
+func (q *handOverQueue) Close() error {
+	return nil
+}
+
 // EOF
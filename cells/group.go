@@ -0,0 +1,140 @@
+// Tideland Go Cells - Groups
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package cells
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strings"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// GroupSeparator separates the names of groups and cells
+// inside of cell IDs, e.g. "orders/validator".
+const GroupSeparator = "/"
+
+//--------------------
+// GROUP
+//--------------------
+
+// group implements the Group interface.
+type group struct {
+	env  *environment
+	name string
+}
+
+// newGroup creates a group of the environment.
+func newGroup(env *environment, name string) *group {
+	return &group{
+		env:  env,
+		name: strings.Trim(name, GroupSeparator),
+	}
+}
+
+// Name implements the Group interface.
+func (g *group) Name() string {
+	return g.name
+}
+
+// ID implements the Group interface.
+func (g *group) ID(id string) string {
+	if g.name == "" {
+		return id
+	}
+	return g.name + GroupSeparator + id
+}
+
+// Group implements the Group interface.
+func (g *group) Group(name string) Group {
+	return newGroup(g.env, g.ID(name))
+}
+
+// StartCell implements the Group interface.
+func (g *group) StartCell(id string, behavior Behavior) error {
+	return g.env.StartCell(g.ID(id), behavior)
+}
+
+// StartCells implements the Group interface.
+func (g *group) StartCells(factory BehaviorFactory, ids ...string) error {
+	for _, id := range ids {
+		fullID := g.ID(id)
+		if err := g.env.StartCell(fullID, factory(fullID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cells implements the Group interface.
+func (g *group) Cells() []string {
+	prefix := ""
+	if g.name != "" {
+		prefix = g.name + GroupSeparator
+	}
+	return g.env.cells.ids(prefix)
+}
+
+// FanIn implements the Group interface.
+func (g *group) FanIn(subscriberID string) error {
+	if !g.env.HasCell(subscriberID) {
+		return errors.New(ErrInvalidID, errorMessages, subscriberID)
+	}
+	return g.do(func(id string) error {
+		if id == subscriberID {
+			return nil
+		}
+		return g.env.Subscribe(id, subscriberID)
+	})
+}
+
+// FanOut implements the Group interface.
+func (g *group) FanOut(emitterID string) error {
+	ids := []string{}
+	for _, id := range g.Cells() {
+		if id != emitterID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return g.env.Subscribe(emitterID, ids...)
+}
+
+// Stop implements the Group interface. Spawned children of the
+// group are already gone when their parent has been stopped.
+func (g *group) Stop() error {
+	return g.do(func(id string) error {
+		err := g.env.StopCell(id)
+		if errors.IsError(err, ErrInvalidID) {
+			return nil
+		}
+		return err
+	})
+}
+
+// do executes the function for all cells of the group
+// and collects the errors.
+func (g *group) do(f func(id string) error) error {
+	var errs []error
+	for _, id := range g.Cells() {
+		if err := f(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return collectErrors(errs)
+}
+
+// EOF
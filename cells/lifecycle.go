@@ -0,0 +1,206 @@
+// Tideland Go Cells - Lifecycle
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package cells
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// LIFECYCLE EVENT
+//--------------------
+
+// LifecycleKind describes what happened to a cell.
+type LifecycleKind int
+
+// Kinds of lifecycle events.
+const (
+	CellStarted LifecycleKind = iota + 1
+	CellStopped
+	CellRecovering
+	CellRecovered
+	CellGivenUp
+	CellSubscribed
+	CellUnsubscribed
+)
+
+// String implements the fmt.Stringer interface.
+func (k LifecycleKind) String() string {
+	switch k {
+	case CellStarted:
+		return "started"
+	case CellStopped:
+		return "stopped"
+	case CellRecovering:
+		return "recovering"
+	case CellRecovered:
+		return "recovered"
+	case CellGivenUp:
+		return "given up"
+	case CellSubscribed:
+		return "subscribed"
+	case CellUnsubscribed:
+		return "unsubscribed"
+	}
+	return "unknown"
+}
+
+// LifecycleEvent describes a change in the lifecycle of a cell. In
+// case of subscriptions the cell is the emitter. The reason contains
+// errors of stopping and recovering.
+type LifecycleEvent struct {
+	Kind         LifecycleKind
+	CellID       string
+	SubscriberID string
+	Reason       string
+	Timestamp    time.Time
+}
+
+// String implements the fmt.Stringer interface.
+func (le LifecycleEvent) String() string {
+	switch le.Kind {
+	case CellSubscribed, CellUnsubscribed:
+		return fmt.Sprintf("<cell %q %s %q>", le.SubscriberID, le.Kind, le.CellID)
+	}
+	if le.Reason != "" {
+		return fmt.Sprintf("<cell %q %s: %s>", le.CellID, le.Kind, le.Reason)
+	}
+	return fmt.Sprintf("<cell %q %s>", le.CellID, le.Kind)
+}
+
+// LifecycleObserver is informed about lifecycle events.
+type LifecycleObserver func(event LifecycleEvent)
+
+//--------------------
+// LIFECYCLE
+//--------------------
+
+// lifecycle dispatches the lifecycle events of an environment
+// asynchronously and in order to the observers.
+type lifecycle struct {
+	mutex     sync.Mutex
+	observers map[int]LifecycleObserver
+	counter   int
+	pending   []LifecycleEvent
+	wakec     chan struct{}
+	loop      loop.Loop
+}
+
+// newLifecycle creates the lifecycle dispatcher. The backend
+// is started with the first observer.
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		observers: make(map[int]LifecycleObserver),
+		wakec:     make(chan struct{}, 1),
+	}
+}
+
+// observe adds an observer and returns the function to remove it.
+func (lc *lifecycle) observe(observer LifecycleObserver) func() {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.loop == nil {
+		lc.loop = loop.Go(lc.backendLoop)
+	}
+	lc.counter++
+	id := lc.counter
+	lc.observers[id] = observer
+	return func() {
+		lc.mutex.Lock()
+		defer lc.mutex.Unlock()
+		delete(lc.observers, id)
+	}
+}
+
+// notify queues a lifecycle event if there are observers.
+func (lc *lifecycle) notify(kind LifecycleKind, cellID, subscriberID string, reason interface{}) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if len(lc.observers) == 0 {
+		return
+	}
+	le := LifecycleEvent{
+		Kind:         kind,
+		CellID:       cellID,
+		SubscriberID: subscriberID,
+		Timestamp:    time.Now().UTC(),
+	}
+	if reason != nil {
+		le.Reason = fmt.Sprintf("%v", reason)
+	}
+	lc.pending = append(lc.pending, le)
+	select {
+	case lc.wakec <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the dispatching after the pending events
+// have been dispatched.
+func (lc *lifecycle) stop() error {
+	lc.mutex.Lock()
+	l := lc.loop
+	lc.mutex.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.Stop()
+}
+
+// dispatch passes the pending events to the observers.
+func (lc *lifecycle) dispatch() {
+	lc.mutex.Lock()
+	pending := lc.pending
+	lc.pending = nil
+	observers := make([]LifecycleObserver, 0, len(lc.observers))
+	for i := 1; i <= lc.counter; i++ {
+		if observer, ok := lc.observers[i]; ok {
+			observers = append(observers, observer)
+		}
+	}
+	lc.mutex.Unlock()
+	for _, le := range pending {
+		for _, observer := range observers {
+			lc.call(observer, le)
+		}
+	}
+}
+
+// call calls one observer and logs its panics.
+func (lc *lifecycle) call(observer LifecycleObserver, le LifecycleEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("lifecycle observer panicked with %v for %v", r, le)
+		}
+	}()
+	observer(le)
+}
+
+// backendLoop dispatches the lifecycle events.
+func (lc *lifecycle) backendLoop(l loop.Loop) error {
+	for {
+		select {
+		case <-l.ShallStop():
+			lc.dispatch()
+			return nil
+		case <-lc.wakec:
+			lc.dispatch()
+		}
+	}
+}
+
+// EOF
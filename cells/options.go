@@ -0,0 +1,112 @@
+// Tideland Go Cells - Options
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package cells
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option allows to configure an environment.
+type Option func(env *environment) error
+
+// DeadLetters sets the ID of the cell receiving the events which
+// are not processed, e.g. because they expired. They are emitted
+// as DeadLetter with the topic "dead-letter". An empty ID stops
+// the routing, those events are only counted then.
+func DeadLetters(id string) Option {
+	return func(env *environment) error {
+		if id != "" && !env.HasCell(id) {
+			return errors.New(ErrInvalidID, errorMessages, id)
+		}
+		env.deadLetterID = id
+		return nil
+	}
+}
+
+// DetectCycles lets subscriptions fail with ErrCycle if they
+// would create a cycle of cells.
+func DetectCycles(detect bool) Option {
+	return func(env *environment) error {
+		env.cells.setDetectCycles(detect)
+		return nil
+	}
+}
+
+// MaxHops sets the maximum number of hops of events. Those with
+// more hops are not processed, but counted and emitted as dead
+// letters. So events circulating in deliberate cycles will be
+// stopped. A value of 0 means no limit, then hops are not counted.
+// Passing events to subscribers with SubscribersDo() counts too.
+// Events emitted by other goroutines of a behavior, e.g. timers,
+// inherit the hops of the event processed at the same time.
+func MaxHops(hops int) Option {
+	return func(env *environment) error {
+		if hops < 0 {
+			hops = 0
+		}
+		atomic.StoreInt64(&env.hops, int64(hops))
+		return nil
+	}
+}
+
+// RingQueues lets cells started afterwards use queues based on
+// ring buffers with the given capacity. They deliver events with
+// less overhead, but emitting into a full queue via the environment
+// fails with ErrQueueFull. Events emitted by cells to their full
+// subscribers are handled as dead letters. Only events behaviors emit
+// to their own cells via the environment of the cell, e.g. by timers,
+// are always accepted.
+// A capacity of 0 switches back to the default queues.
+func RingQueues(capacity int) Option {
+	return func(env *environment) error {
+		if capacity <= 0 {
+			env.newQueue = nil
+			return nil
+		}
+		env.newQueue = func() Queue {
+			return newRingQueue(capacity)
+		}
+		return nil
+	}
+}
+
+//--------------------
+// DEAD LETTER
+//--------------------
+
+// Reasons for dead letters.
+const (
+	DeadLetterExpired     = "expired"
+	DeadLetterMaxHops     = "max-hops"
+	DeadLetterBatchFailed = "batch-failed"
+	DeadLetterQueueFull   = "queue-full"
+)
+
+// DeadLetter is the payload of events which have not been
+// processed. It contains the reason, the ID of the cell the
+// event has been emitted to, and the data of the event.
+type DeadLetter struct {
+	Reason    string
+	CellID    string
+	Topic     string
+	Timestamp time.Time
+	Payload   []byte
+}
+
+// EOF
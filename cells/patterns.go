@@ -0,0 +1,103 @@
+// Tideland Go Cells - Topic Patterns
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package cells
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"path"
+	"strings"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// TOPIC PATTERNS
+//--------------------
+
+// topicPattern is one parsed topic pattern.
+type topicPattern []string
+
+// topicPatterns is a set of topic patterns, nil matches all topics.
+type topicPatterns []topicPattern
+
+// newTopicPatterns parses and validates the passed patterns. Topics
+// and patterns consist of segments separated by dots. A "*" matches
+// exactly one segment, a "**" any number of segments. Other segments
+// are matched like path.Match() does.
+func newTopicPatterns(patterns ...string) (topicPatterns, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	tps := make(topicPatterns, len(patterns))
+	for i, pattern := range patterns {
+		if pattern == "" {
+			return nil, errors.New(ErrInvalidPattern, errorMessages, pattern)
+		}
+		segments := strings.Split(pattern, ".")
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, errors.New(ErrInvalidPattern, errorMessages, pattern)
+			}
+		}
+		tps[i] = topicPattern(segments)
+	}
+	return tps, nil
+}
+
+// strings returns the original patterns.
+func (tps topicPatterns) strings() []string {
+	if tps == nil {
+		return nil
+	}
+	patterns := make([]string, len(tps))
+	for i, tp := range tps {
+		patterns[i] = strings.Join(tp, ".")
+	}
+	return patterns
+}
+
+// match checks if one of the patterns matches the topic.
+func (tps topicPatterns) match(topic string) bool {
+	if tps == nil {
+		return true
+	}
+	segments := strings.Split(topic, ".")
+	for _, tp := range tps {
+		if tp.match(segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// match checks if the pattern matches the topic segments.
+func (tp topicPattern) match(segments []string) bool {
+	if len(tp) == 0 {
+		return len(segments) == 0
+	}
+	if tp[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if tp[1:].match(segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(tp[0], segments[0]); !ok {
+		return false
+	}
+	return tp[1:].match(segments[1:])
+}
+
+// EOF
@@ -14,6 +14,8 @@ package cells
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sync"
 
 	"github.com/tideland/golib/errors"
 )
@@ -40,7 +42,8 @@ type Payload interface {
 
 // payload implements the Payload interface.
 type payload struct {
-	Data []byte
+	Data    []byte
+	decoded sync.Map
 }
 
 // NewPayload creates a new payload based on the passed value. In
@@ -104,4 +107,130 @@ func (p *payload) String() string {
 	return string(p.Data)
 }
 
+//--------------------
+// TYPED PAYLOAD
+//--------------------
+
+// typedPayload implements the Payload interface for a Go value
+// which is passed to all receivers without copying. It is only
+// marshalled into JSON when its bytes are needed, e.g. when
+// events are persisted or leave the process.
+type typedPayload struct {
+	value interface{}
+	once  sync.Once
+	data  []byte
+	err   error
+}
+
+// NewTypedPayload creates a payload holding the passed value. It
+// is shared by all receivers, so by convention it must not be
+// changed anymore. They retrieve it with PayloadAs().
+func NewTypedPayload(v interface{}) Payload {
+	if v == nil {
+		return newEmptyPayload()
+	}
+	return &typedPayload{
+		value: v,
+	}
+}
+
+// marshal lazily marshals the value into JSON. Like with
+// NewPayload() byte slices and strings are taken directly.
+func (p *typedPayload) marshal() ([]byte, error) {
+	p.once.Do(func() {
+		switch tv := p.value.(type) {
+		case []byte:
+			p.data = tv
+		case string:
+			p.data = []byte(tv)
+		default:
+			p.data, p.err = json.Marshal(p.value)
+			if p.err != nil {
+				p.err = errors.Annotate(p.err, ErrMarshal, errorMessages)
+			}
+		}
+	})
+	return p.data, p.err
+}
+
+// Len implements Payload.
+func (p *typedPayload) Len() int {
+	data, _ := p.marshal()
+	return len(data)
+}
+
+// Bytes implements Payload.
+func (p *typedPayload) Bytes() []byte {
+	data, _ := p.marshal()
+	bytes := make([]byte, len(data))
+	copy(bytes, data)
+	return bytes
+}
+
+// Unmarshal implements Payload. If v points to a value of
+// the type of the payload value it is directly assigned.
+func (p *typedPayload) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		pv := reflect.ValueOf(p.value)
+		if pv.Type().AssignableTo(rv.Elem().Type()) {
+			rv.Elem().Set(pv)
+			return nil
+		}
+	}
+	data, err := p.marshal()
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return errors.Annotate(err, ErrUnmarshal, errorMessages)
+	}
+	return nil
+}
+
+// String implements fmt.Stringer.
+func (p *typedPayload) String() string {
+	data, _ := p.marshal()
+	return string(data)
+}
+
+//--------------------
+// PAYLOAD ACCESS
+//--------------------
+
+// PayloadAs returns the value of the payload as type T. Values of
+// typed payloads are returned directly, strings and byte slices of
+// other payloads raw. Otherwise the payload is decoded only once per
+// type and the result is shared, so like the values of typed payloads
+// it must not be changed.
+func PayloadAs[T any](p Payload) (T, error) {
+	var value T
+	switch tp := p.(type) {
+	case *typedPayload:
+		if v, ok := tp.value.(T); ok {
+			return v, nil
+		}
+	case *payload:
+		switch tv := interface{}(&value).(type) {
+		case *string:
+			*tv = tp.String()
+			return value, nil
+		case *[]byte:
+			*tv = tp.Bytes()
+			return value, nil
+		}
+		t := reflect.TypeOf(&value).Elem()
+		if v, ok := tp.decoded.Load(t); ok {
+			return v.(T), nil
+		}
+		if err := tp.Unmarshal(&value); err != nil {
+			return value, err
+		}
+		tp.decoded.Store(t, value)
+		return value, nil
+	}
+	err := p.Unmarshal(&value)
+	return value, err
+}
+
 // EOF
@@ -12,6 +12,9 @@ package cells
 //--------------------
 
 import (
+	"sync"
+
+	"github.com/tideland/golib/errors"
 	"github.com/tideland/golib/loop"
 )
 
@@ -22,6 +25,12 @@ import (
 // TODO(mue) maxPending will later limit the queue size.
 const maxPending = 65536
 
+// ownEventsQueue is implemented by queues with a fixed capacity
+// which nevertheless accept all events a cell emits to itself.
+type ownEventsQueue interface {
+	emitOwn(event Event) error
+}
+
 //--------------------
 // IN-MEMORY QUEUE
 //--------------------
@@ -89,4 +98,136 @@ func (q *inMemoryQueue) backendLoop(l loop.Loop) error {
 	}
 }
 
+//--------------------
+// RING QUEUE
+//--------------------
+
+// ringQueue implements Queue based on a ring buffer with a fixed
+// capacity. Emitting only locks the buffer, the backend takes all
+// buffered events at once and delivers them via a buffered channel.
+// Events a cell emits to itself are kept in an overflow if the
+// buffer is full, so that behaviors don't lose e.g. timer events.
+type ringQueue struct {
+	mutex    sync.Mutex
+	buffer   []Event
+	head     int
+	count    int
+	overflow []Event
+	closed   bool
+	signalc  chan struct{}
+	outc     chan Event
+	loop     loop.Loop
+}
+
+// newRingQueue creates the ring queue.
+func newRingQueue(capacity int) Queue {
+	if capacity < minEventBufferSize {
+		capacity = minEventBufferSize
+	}
+	q := &ringQueue{
+		buffer:  make([]Event, capacity),
+		signalc: make(chan struct{}, 1),
+		outc:    make(chan Event, minEventBufferSize),
+	}
+	q.loop = loop.Go(q.backendLoop)
+	return q
+}
+
+// Emit implements the Queue interface.
+func (q *ringQueue) Emit(event Event) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errors.New(ErrStopping, errorMessages, "queue")
+	}
+	if q.count == len(q.buffer) {
+		q.mutex.Unlock()
+		return errors.New(ErrQueueFull, errorMessages, len(q.buffer))
+	}
+	q.buffer[(q.head+q.count)%len(q.buffer)] = event
+	q.count++
+	q.mutex.Unlock()
+	q.signal()
+	return nil
+}
+
+// emitOwn emits an event of the cell to itself. If the buffer is
+// full it is appended to the overflow. Events already there keep
+// their order.
+func (q *ringQueue) emitOwn(event Event) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errors.New(ErrStopping, errorMessages, "queue")
+	}
+	if q.count == len(q.buffer) || len(q.overflow) > 0 {
+		q.overflow = append(q.overflow, event)
+	} else {
+		q.buffer[(q.head+q.count)%len(q.buffer)] = event
+		q.count++
+	}
+	q.mutex.Unlock()
+	q.signal()
+	return nil
+}
+
+// signal wakes up the backend if it's waiting.
+func (q *ringQueue) signal() {
+	select {
+	case q.signalc <- struct{}{}:
+	default:
+	}
+}
+
+// Events implements the Queue interface.
+func (q *ringQueue) Events() <-chan Event {
+	return q.outc
+}
+
+// Close implements the Queue interface.
+func (q *ringQueue) Close() error {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	return q.loop.Stop()
+}
+
+// take moves all buffered events and then the overflow
+// into the batch.
+func (q *ringQueue) take(batch []Event) []Event {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for ; q.count > 0; q.count-- {
+		batch = append(batch, q.buffer[q.head])
+		q.buffer[q.head] = nil
+		q.head = (q.head + 1) % len(q.buffer)
+	}
+	batch = append(batch, q.overflow...)
+	q.overflow = nil
+	return batch
+}
+
+// backendLoop runs the queue goroutine.
+func (q *ringQueue) backendLoop(l loop.Loop) error {
+	defer close(q.outc)
+
+	var batch []Event
+
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case <-q.signalc:
+		}
+		batch = q.take(batch[:0])
+		for _, event := range batch {
+			select {
+			case <-l.ShallStop():
+				return nil
+			case q.outc <- event:
+			}
+		}
+	}
+}
+
 // EOF
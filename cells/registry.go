@@ -12,88 +12,378 @@ package cells
 //--------------------
 
 import (
+	"hash/fnv"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/logger"
 )
 
 //--------------------
 // CELL REGISTRY
 //--------------------
 
-// registry manages the mapping of identifiers to cells.
+// registryShards is the number of shards the cells of a registry
+// are distributed to.
+const registryShards = 32
+
+// registryShard contains a part of the cells of a registry. Cells
+// still initializing are reserved, so that they can already receive
+// events but are not visible otherwise.
+type registryShard struct {
+	mutex    sync.RWMutex
+	cells    map[string]*cell
+	reserved map[string]*cell
+}
+
+// registry manages the mapping of identifiers to cells. The cells
+// are distributed to shards by their IDs, so that lookups only lock
+// one of them. The behaviors are initialized outside of any lock.
+// Changes of the topology like subscriptions are serialized. Cells
+// are stopped outside of the lock too, so that their behaviors still
+// can spawn children meanwhile.
 type registry struct {
-	mutex sync.RWMutex
-	cells map[string]*cell
+	mutex        sync.Mutex
+	shards       [registryShards]registryShard
+	detectCycles bool
+	stopping     bool
 }
 
 // newRegistry creates a new cell registry.
 func newRegistry() *registry {
-	return &registry{
-		cells: make(map[string]*cell),
+	r := &registry{}
+	for i := range r.shards {
+		r.shards[i].cells = make(map[string]*cell)
+		r.shards[i].reserved = make(map[string]*cell)
+	}
+	return r
+}
+
+// shard returns the shard responsible for the ID.
+func (r *registry) shard(id string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &r.shards[h.Sum32()%registryShards]
+}
+
+// lookup returns the cell with the given ID if it is registered
+// and initialized.
+func (r *registry) lookup(id string) (*cell, bool) {
+	s := r.shard(id)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, ok := s.cells[id]
+	return c, ok
+}
+
+// receiver returns the cell with the given ID for emitting
+// events to it. It may still be initializing.
+func (r *registry) receiver(id string) (*cell, error) {
+	s := r.shard(id)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if c, ok := s.cells[id]; ok {
+		return c, nil
+	}
+	if c, ok := s.reserved[id]; ok {
+		return c, nil
+	}
+	return nil, errors.New(ErrInvalidID, errorMessages, id)
+}
+
+// reserve reserves the ID for a cell to be initialized. It
+// fails if the registry is stopping.
+func (r *registry) reserve(c *cell) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stopping {
+		return errors.New(ErrStopping, errorMessages, "registry")
+	}
+	s := r.shard(c.id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.cells[c.id]; ok {
+		return errors.New(ErrDuplicateID, errorMessages, c.id)
+	}
+	if _, ok := s.reserved[c.id]; ok {
+		return errors.New(ErrDuplicateID, errorMessages, c.id)
+	}
+	s.reserved[c.id] = c
+	return nil
+}
+
+// fill registers the initialized cell reserved before. It fails
+// if the reservation has been dropped when stopping the registry.
+func (r *registry) fill(c *cell) bool {
+	s := r.shard(c.id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reserved[c.id] != c {
+		return false
+	}
+	delete(s.reserved, c.id)
+	s.cells[c.id] = c
+	return true
+}
+
+// remove removes a cell or a reservation.
+func (r *registry) remove(id string) {
+	s := r.shard(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.cells, id)
+	delete(s.reserved, id)
+}
+
+// all returns all initialized cells sorted by their IDs.
+func (r *registry) all() []*cell {
+	var all []*cell
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mutex.RLock()
+		for _, c := range s.cells {
+			all = append(all, c)
+		}
+		s.mutex.RUnlock()
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].id < all[j].id
+	})
+	return all
+}
+
+// clear removes all cells and reservations. The removed cells
+// are returned sorted by their IDs, so that they can be stopped.
+func (r *registry) clear() []*cell {
+	var removed []*cell
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mutex.Lock()
+		for _, c := range s.cells {
+			removed = append(removed, c)
+		}
+		s.cells = make(map[string]*cell)
+		s.reserved = make(map[string]*cell)
+		s.mutex.Unlock()
 	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].id < removed[j].id
+	})
+	return removed
 }
 
 // stop stops the registry.
 func (r *registry) stop() error {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	for _, rc := range r.cells {
+	r.stopping = true
+	removed := r.clear()
+	r.mutex.Unlock()
+	var errs []error
+	for _, rc := range removed {
 		if err := rc.stop(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	r.cells = make(map[string]*cell)
-	return nil
+	return collectErrors(errs)
 }
 
-// startCell starts and adds a new cell to the registry if the
-// ID does not already exist.
-func (r *registry) startCell(env *environment, id string, behavior Behavior) error {
+// drain stops the cells in topological order from sources to
+// sinks after they processed their pending events. Those cells
+// which could not process all events until the deadline are
+// reported in the returned error. Cells started while draining
+// are stopped at last.
+func (r *registry) drain(deadline time.Time) error {
+	r.mutex.Lock()
+	r.stopping = true
+	ordered := r.topologicalOrder()
+	r.mutex.Unlock()
+	var errs []error
+	for _, rc := range ordered {
+		if lost := rc.drain(deadline); lost > 0 {
+			logger.Warningf("cell %q lost %d events while draining", rc.id, lost)
+			errs = append(errs, errors.New(ErrEventsLost, errorMessages, rc.id, lost))
+		}
+		if err := rc.stop(); err != nil {
+			errs = append(errs, err)
+		}
+		r.remove(rc.id)
+	}
+	for _, rc := range r.clear() {
+		if err := rc.stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return collectErrors(errs)
+}
+
+// topologicalOrder returns the cells ordered from sources to
+// sinks. Cells in cycles follow in the order of their IDs.
+func (r *registry) topologicalOrder() []*cell {
+	all := r.all()
+	emitters := make(map[string]int)
+	for _, c := range all {
+		for _, subscriberID := range c.subscribers.ids() {
+			emitters[subscriberID]++
+		}
+	}
+	ordered := make([]*cell, 0, len(all))
+	done := make(map[*cell]bool)
+	for len(ordered) < len(all) {
+		// Take all cells without emitters, or the first one
+		// remaining if all are in cycles.
+		var next []*cell
+		for _, c := range all {
+			if !done[c] && emitters[c.id] == 0 {
+				next = append(next, c)
+			}
+		}
+		if len(next) == 0 {
+			for _, c := range all {
+				if !done[c] {
+					next = append(next, c)
+					break
+				}
+			}
+		}
+		for _, c := range next {
+			done[c] = true
+			ordered = append(ordered, c)
+			for _, subscriberID := range c.subscribers.ids() {
+				emitters[subscriberID]--
+			}
+		}
+	}
+	return ordered
+}
+
+// setDetectCycles switches the detection of cycles.
+func (r *registry) setDetectCycles(detect bool) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	// Check if the ID already exists.
-	if _, ok := r.cells[id]; ok {
-		return errors.New(ErrDuplicateID, errorMessages, id)
+	r.detectCycles = detect
+}
+
+// startCell starts and adds a new cell to the registry if the
+// ID does not already exist. The ID is reserved while the
+// behavior is initialized.
+func (r *registry) startCell(env *environment, id string, behavior Behavior) error {
+	rc := newCell(env, id, behavior)
+	if err := r.reserve(rc); err != nil {
+		rc.discard()
+		return err
+	}
+	if err := rc.init(); err != nil {
+		r.remove(id)
+		return err
+	}
+	if !r.fill(rc) {
+		rc.terminate()
+		return errors.New(ErrStopping, errorMessages, "registry")
+	}
+	env.lifecycle.notify(CellStarted, id, "", nil)
+	return nil
+}
+
+// startChild starts a child cell and subscribes the parent to it.
+func (r *registry) startChild(parent *cell, id string, behavior Behavior) error {
+	if _, ok := r.lookup(parent.id); !ok {
+		return errors.New(ErrInvalidID, errorMessages, parent.id)
+	}
+	rc := newCell(parent.env, id, behavior)
+	if err := r.reserve(rc); err != nil {
+		rc.discard()
+		return err
 	}
-	// Create and add.
-	rc, err := newCell(env, id, behavior)
+	if err := rc.init(); err != nil {
+		r.remove(id)
+		return err
+	}
+	rc.parent = parent
+	r.mutex.Lock()
+	err := r.addChild(parent, rc)
+	r.mutex.Unlock()
 	if err != nil {
+		// Never started, so stop it silently outside of the lock.
+		r.remove(id)
+		rc.terminate()
 		return err
 	}
-	r.cells[id] = rc
 	return nil
 }
 
-// stopCell stops a cell.
+// addChild registers the initialized child and subscribes the
+// parent to it. The registry has to be locked.
+func (r *registry) addChild(parent, rc *cell) error {
+	if r.stopping {
+		return errors.New(ErrStopping, errorMessages, "registry")
+	}
+	// The parent may have been stopped meanwhile.
+	if _, ok := r.lookup(parent.id); !ok {
+		return errors.New(ErrInvalidID, errorMessages, parent.id)
+	}
+	if !r.fill(rc) {
+		return errors.New(ErrStopping, errorMessages, "registry")
+	}
+	parent.env.lifecycle.notify(CellStarted, rc.id, "", nil)
+	rc.subscribers.add(parent, nil, nil)
+	parent.emitters.add(rc, nil, nil)
+	parent.addChild(rc.id)
+	parent.env.lifecycle.notify(CellSubscribed, rc.id, parent.id, nil)
+	return nil
+}
+
+// stopCell removes a cell and its children from the registry
+// and stops them afterwards, the children first.
 func (r *registry) stopCell(id string) error {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	rc, ok := r.cells[id]
+	rc, ok := r.lookup(id)
 	if !ok {
+		r.mutex.Unlock()
 		return errors.New(ErrInvalidID, errorMessages, id)
 	}
-	// Stop the cell.
-	if err := rc.stop(); err != nil {
-		return err
+	tree := r.removeTree(rc, nil)
+	r.mutex.Unlock()
+	var errs []error
+	for _, tc := range tree {
+		if err := tc.stop(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	// Remove the cell from the registry.
-	delete(r.cells, id)
-	return nil
+	return collectErrors(errs)
+}
+
+// removeTree removes the children of a cell and then the cell
+// itself from the registry. They are appended to the passed
+// cells in this order.
+func (r *registry) removeTree(rc *cell, tree []*cell) []*cell {
+	for _, childID := range rc.Children() {
+		if child, ok := r.lookup(childID); ok {
+			tree = r.removeTree(child, tree)
+		}
+	}
+	r.remove(rc.id)
+	return append(tree, rc)
 }
 
 // subscribe subscribes cells to an emitter.
 func (r *registry) subscribe(emitterID string, subscriberIDs ...string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	ec, ok := r.cells[emitterID]
+	ec, ok := r.lookup(emitterID)
 	if !ok {
 		return errors.New(ErrInvalidID, errorMessages, emitterID)
 	}
 	for _, subscriberID := range subscriberIDs {
-		if sc, ok := r.cells[subscriberID]; ok {
-			ec.subscribers.add(sc)
-			sc.emitters.add(ec)
+		if sc, ok := r.lookup(subscriberID); ok {
+			if err := r.checkCycle(ec, sc); err != nil {
+				return err
+			}
+			ec.subscribers.add(sc, nil, nil)
+			sc.emitters.add(ec, nil, nil)
+			ec.env.lifecycle.notify(CellSubscribed, emitterID, subscriberID, nil)
 		} else {
 			return errors.New(ErrInvalidID, errorMessages, subscriberID)
 		}
@@ -101,18 +391,45 @@ func (r *registry) subscribe(emitterID string, subscriberIDs ...string) error {
 	return nil
 }
 
+// subscribeFiltered subscribes a cell to the events of an emitter
+// matching the topic patterns and accepted by the filter.
+func (r *registry) subscribeFiltered(emitterID, subscriberID string, filter SubscriptionFilter, patterns ...string) error {
+	tps, err := newTopicPatterns(patterns...)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ec, ok := r.lookup(emitterID)
+	if !ok {
+		return errors.New(ErrInvalidID, errorMessages, emitterID)
+	}
+	sc, ok := r.lookup(subscriberID)
+	if !ok {
+		return errors.New(ErrInvalidID, errorMessages, subscriberID)
+	}
+	if err := r.checkCycle(ec, sc); err != nil {
+		return err
+	}
+	ec.subscribers.add(sc, tps, filter)
+	sc.emitters.add(ec, nil, nil)
+	ec.env.lifecycle.notify(CellSubscribed, emitterID, subscriberID, nil)
+	return nil
+}
+
 // unsubscribe usubscribes cells from an emitter.
 func (r *registry) unsubscribe(emitterID string, subscriberIDs ...string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	ec, ok := r.cells[emitterID]
+	ec, ok := r.lookup(emitterID)
 	if !ok {
 		return errors.New(ErrInvalidID, errorMessages, emitterID)
 	}
 	for _, subscriberID := range subscriberIDs {
-		if sc, ok := r.cells[subscriberID]; ok {
+		if sc, ok := r.lookup(subscriberID); ok {
 			ec.subscribers.remove(subscriberID)
 			sc.emitters.remove(emitterID)
+			ec.env.lifecycle.notify(CellUnsubscribed, emitterID, subscriberID, nil)
 		} else {
 			return errors.New(ErrInvalidID, errorMessages, subscriberID)
 		}
@@ -122,24 +439,86 @@ func (r *registry) unsubscribe(emitterID string, subscriberIDs ...string) error
 
 // subscribers returns the IDs of the subscribers of one cell.
 func (r *registry) subscribers(emitterID string) ([]string, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-	ec, ok := r.cells[emitterID]
+	ec, ok := r.lookup(emitterID)
 	if !ok {
 		return nil, errors.New(ErrInvalidID, errorMessages, emitterID)
 	}
 	return ec.subscribers.ids(), nil
 }
 
+// subscriptions returns the topic patterns of the subscribers
+// of one cell.
+func (r *registry) subscriptions(emitterID string) (map[string][]string, error) {
+	ec, ok := r.lookup(emitterID)
+	if !ok {
+		return nil, errors.New(ErrInvalidID, errorMessages, emitterID)
+	}
+	return ec.subscribers.patterns(), nil
+}
+
+// checkCycle checks if subscribing the subscriber cell to the
+// emitter cell creates a cycle if the detection is switched on.
+func (r *registry) checkCycle(ec, sc *cell) error {
+	if !r.detectCycles {
+		return nil
+	}
+	visited := map[*cell]bool{}
+	var reaches func(c *cell) bool
+	reaches = func(c *cell) bool {
+		if c == ec {
+			return true
+		}
+		if visited[c] {
+			return false
+		}
+		visited[c] = true
+		found := false
+		c.subscribers.do(func(csc *cell) error {
+			if !found && reaches(csc) {
+				found = true
+			}
+			return nil
+		})
+		return found
+	}
+	if reaches(sc) {
+		return errors.New(ErrCycle, errorMessages, sc.id, ec.id)
+	}
+	return nil
+}
+
+// ids returns the sorted IDs of the cells starting
+// with the prefix.
+func (r *registry) ids(prefix string) []string {
+	ids := []string{}
+	for _, c := range r.all() {
+		if strings.HasPrefix(c.id, prefix) {
+			ids = append(ids, c.id)
+		}
+	}
+	return ids
+}
+
 // cell returns the cell with the given id.
 func (r *registry) cell(id string) (*cell, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-	c, ok := r.cells[id]
+	c, ok := r.lookup(id)
 	if !ok {
 		return nil, errors.New(ErrInvalidID, errorMessages, id)
 	}
 	return c, nil
 }
 
+// collectErrors returns nil, the only one, or the collection
+// of the passed errors.
+func collectErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errors.Collect(errs...)
+	}
+}
+
 // EOF
@@ -0,0 +1,298 @@
+// Tideland Go Cells - Timers
+//
+// Copyright (C) 2010-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package cells
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// SCHEDULED EVENT
+//--------------------
+
+// scheduled implements the Scheduled interface.
+type scheduled struct {
+	timers *timers
+	id     string
+	at     time.Time
+	event  Event
+	index  int
+}
+
+// ID implements the Scheduled interface.
+func (s *scheduled) ID() string {
+	return s.id
+}
+
+// At implements the Scheduled interface.
+func (s *scheduled) At() time.Time {
+	return s.at
+}
+
+// Event implements the Scheduled interface.
+func (s *scheduled) Event() Event {
+	return s.event
+}
+
+// Cancel implements the Scheduled interface.
+func (s *scheduled) Cancel() bool {
+	return s.timers.cancel(s)
+}
+
+// scheduledSnapshot is the persistent form of a scheduled event.
+type scheduledSnapshot struct {
+	ID        string
+	At        time.Time
+	Timestamp time.Time
+	Topic     string
+	Payload   []byte
+	Deadline  time.Time
+}
+
+//--------------------
+// TIMER HEAP
+//--------------------
+
+// timerHeap orders the scheduled events by their time.
+type timerHeap []*scheduled
+
+// Len implements the heap.Interface.
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+// Less implements the heap.Interface.
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at)
+}
+
+// Swap implements the heap.Interface.
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push implements the heap.Interface.
+func (h *timerHeap) Push(x interface{}) {
+	s := x.(*scheduled)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+// Pop implements the heap.Interface.
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	s := old[n-1]
+	old[n-1] = nil
+	s.index = -1
+	*h = old[:n-1]
+	return s
+}
+
+//--------------------
+// TIMERS
+//--------------------
+
+// timers delivers scheduled events of an environment using
+// one timer for all of them.
+type timers struct {
+	mutex   sync.Mutex
+	env     *environment
+	heap    timerHeap
+	wakec   chan struct{}
+	loop    loop.Loop
+	stopped bool
+}
+
+// newTimers creates the timers of an environment. The backend
+// is started with the first scheduled event.
+func newTimers(env *environment) *timers {
+	return &timers{
+		env:   env,
+		wakec: make(chan struct{}, 1),
+	}
+}
+
+// schedule adds an event for the delivery at the given time. It
+// fails after the timers have been stopped.
+func (t *timers) schedule(id string, at time.Time, event Event) (Scheduled, error) {
+	s := &scheduled{
+		timers: t,
+		id:     id,
+		at:     at,
+		event:  event,
+	}
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		return nil, errors.New(ErrStopping, errorMessages, "timers")
+	}
+	if t.loop == nil {
+		t.loop = loop.Go(t.backendLoop)
+	}
+	heap.Push(&t.heap, s)
+	first := t.heap[0] == s
+	t.mutex.Unlock()
+	if first {
+		t.wake()
+	}
+	return s, nil
+}
+
+// cancel removes a scheduled event. It returns false if
+// it is not scheduled anymore.
+func (t *timers) cancel(s *scheduled) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if s.index < 0 || s.index >= len(t.heap) || t.heap[s.index] != s {
+		return false
+	}
+	heap.Remove(&t.heap, s.index)
+	return true
+}
+
+// snapshot returns the pending scheduled events as JSON.
+func (t *timers) snapshot() ([]byte, error) {
+	t.mutex.Lock()
+	snapshots := make([]scheduledSnapshot, len(t.heap))
+	for i, s := range t.heap {
+		deadline, _ := s.event.Deadline()
+		snapshots[i] = scheduledSnapshot{
+			ID:        s.id,
+			At:        s.at,
+			Timestamp: s.event.Timestamp(),
+			Topic:     s.event.Topic(),
+			Payload:   s.event.Payload().Bytes(),
+			Deadline:  deadline,
+		}
+	}
+	t.mutex.Unlock()
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrMarshal, errorMessages)
+	}
+	return data, nil
+}
+
+// restore schedules the events of a snapshot. All of their
+// cells have to exist.
+func (t *timers) restore(data []byte) error {
+	var snapshots []scheduledSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return errors.Annotate(err, ErrUnmarshal, errorMessages)
+	}
+	t.mutex.Lock()
+	stopped := t.stopped
+	t.mutex.Unlock()
+	if stopped {
+		return errors.New(ErrStopping, errorMessages, "timers")
+	}
+	events := make([]Event, len(snapshots))
+	for i, snapshot := range snapshots {
+		if _, err := t.env.cells.cell(snapshot.ID); err != nil {
+			return err
+		}
+		p, err := NewPayload(snapshot.Payload)
+		if err != nil {
+			return err
+		}
+		events[i] = &event{
+			timestamp: snapshot.Timestamp,
+			topic:     snapshot.Topic,
+			payload:   p,
+			deadline:  snapshot.Deadline,
+		}
+	}
+	for i, snapshot := range snapshots {
+		if _, err := t.schedule(snapshot.ID, snapshot.At, events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop stops the delivery, pending events are dropped. Afterwards
+// no events can be scheduled anymore.
+func (t *timers) stop() error {
+	t.mutex.Lock()
+	t.stopped = true
+	l := t.loop
+	t.mutex.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.Stop()
+}
+
+// wake tells the backend to check the first event again.
+func (t *timers) wake() {
+	select {
+	case t.wakec <- struct{}{}:
+	default:
+	}
+}
+
+// due removes and returns the due events and returns
+// the duration until the next one.
+func (t *timers) due(now time.Time) ([]*scheduled, time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var due []*scheduled
+	for len(t.heap) > 0 && !t.heap[0].at.After(now) {
+		due = append(due, heap.Pop(&t.heap).(*scheduled))
+	}
+	if len(t.heap) == 0 {
+		return due, 0, false
+	}
+	return due, t.heap[0].at.Sub(now), true
+}
+
+// backendLoop delivers the due events.
+func (t *timers) backendLoop(l loop.Loop) error {
+	for {
+		due, next, ok := t.due(time.Now())
+		for _, s := range due {
+			if err := t.env.Emit(s.id, s.event); err != nil {
+				logger.Errorf("cannot deliver scheduled event %v to cell %q: %v", s.event, s.id, err)
+			}
+		}
+		var timer *time.Timer
+		var timerc <-chan time.Time
+		if ok {
+			timer = time.NewTimer(next)
+			timerc = timer.C
+		}
+		select {
+		case <-l.ShallStop():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case <-t.wakec:
+		case <-timerc:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// EOF